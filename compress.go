@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	compressNone = ""
+	compressGzip = "gzip"
+	compressZstd = "zstd"
+)
+
+// magic numbers at the start of compressed recipes, which is how cook detects the compression
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// newCompressWriter returns a writer that compresses everything written to it into w with the
+// algorithm, which must be closed to flush the output. It doesn't close w.
+func newCompressWriter(w io.Writer, algorithm string) (io.WriteCloser, error) {
+	switch algorithm {
+	case compressNone:
+		return nopWriteCloser{w}, nil
+	case compressGzip:
+		return gzip.NewWriterLevel(w, gzip.BestCompression)
+	case compressZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+	default:
+		return nil, fmt.Errorf("unknown compression %q", algorithm)
+	}
+}
+
+// decompressRecipe returns the recipe JSON from the contents of a recipe file, which may be
+// compressed with any of the algorithms supported by -compress.
+func decompressRecipe(contents []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(contents, gzipMagic):
+		zr, err := gzip.NewReader(bytes.NewReader(contents))
+		if err != nil {
+			return nil, fmt.Errorf("could not decompress gzip recipe: %w", err)
+		}
+		defer zr.Close()
+		out, err := io.ReadAll(zr)
+		if err != nil {
+			return nil, fmt.Errorf("could not decompress gzip recipe: %w", err)
+		}
+		return out, nil
+	case bytes.HasPrefix(contents, zstdMagic):
+		zr, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, fmt.Errorf("could not decompress zstd recipe: %w", err)
+		}
+		defer zr.Close()
+		out, err := zr.DecodeAll(contents, nil)
+		if err != nil {
+			return nil, fmt.Errorf("could not decompress zstd recipe: %w", err)
+		}
+		return out, nil
+	default:
+		return contents, nil
+	}
+}
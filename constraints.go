@@ -0,0 +1,351 @@
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"go/build/constraint"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// matchOSTag returns whether the tag is satisfied when building for goos, following the same
+// rules as go/build: "unix" matches any Unix-like system, and a few systems also match the tag of
+// the system they're derived from.
+func matchOSTag(tag, goos string) bool {
+	switch {
+	case tag == goos:
+		return true
+	case tag == "unix":
+		return unixOS[goos]
+	case tag == "linux" && goos == "android":
+		return true
+	case tag == "solaris" && goos == "illumos":
+		return true
+	case tag == "darwin" && goos == "ios":
+		return true
+	default:
+		return false
+	}
+}
+
+// maxFreeTags limits how many tags constraintSatisfiable will enumerate every combination of
+const maxFreeTags = 12
+
+// constraintSatisfiable returns whether there's any build configuration for which the build
+// constraint is true.
+//
+// Unlike plain boolean satisfiability, this knows that every build has exactly one GOOS and one
+// GOARCH, so an expression like "linux && windows" is never satisfied. All other tags (custom
+// tags, "cgo", release tags, ...) may be freely set or unset.
+//
+// For expressions with an unreasonably large number of other tags, this just returns true.
+func constraintSatisfiable(x constraint.Expr) bool {
+	var arches, freeTags []string
+	for _, tag := range constraintTags(x) {
+		switch {
+		case knownOS[tag] || tag == "unix":
+			// handled by trying every GOOS below
+		case knownArch[tag]:
+			arches = append(arches, tag)
+		default:
+			freeTags = append(freeTags, tag)
+		}
+	}
+	if len(freeTags) > maxFreeTags {
+		return true
+	}
+	// Also try one GOARCH that's not mentioned at all, if there is one.
+	for arch := range knownArch {
+		if !slices.Contains(arches, arch) {
+			arches = append(arches, arch)
+			break
+		}
+	}
+
+	for goos := range knownOS {
+		for _, goarch := range arches {
+			for set := 0; set < 1<<len(freeTags); set++ {
+				ok := x.Eval(func(tag string) bool {
+					if i := slices.Index(freeTags, tag); i != -1 {
+						return set&(1<<i) != 0
+					}
+					return tag == goarch || matchOSTag(tag, goos)
+				})
+				if ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// constraintTags returns the distinct tags used in the build constraint, in order of appearance
+func constraintTags(x constraint.Expr) []string {
+	var tags []string
+	var walk func(x constraint.Expr)
+	walk = func(x constraint.Expr) {
+		switch x := x.(type) {
+		case *constraint.TagExpr:
+			if !slices.Contains(tags, x.Tag) {
+				tags = append(tags, x.Tag)
+			}
+		case *constraint.NotExpr:
+			walk(x.X)
+		case *constraint.AndExpr:
+			walk(x.X)
+			walk(x.Y)
+		case *constraint.OrExpr:
+			walk(x.X)
+			walk(x.Y)
+		}
+	}
+	walk(x)
+	return tags
+}
+
+// filenameConstraint returns the implicit build constraint from a file name's GOOS and GOARCH
+// suffixes, following the same rules as go/build:
+//
+//	*_GOOS.go, *_GOARCH.go, *_GOOS_GOARCH.go
+//
+// (and likewise with a trailing "_test"). The part of the name before the first underscore never
+// counts, so "linux.go" has no constraint. Returns nil if the name has no such suffix.
+func filenameConstraint(filename string) constraint.Expr {
+	name, _, _ := strings.Cut(filename, ".")
+	i := strings.Index(name, "_")
+	if i < 0 {
+		return nil
+	}
+	name = strings.TrimSuffix(name[i:], "_test")
+
+	l := strings.Split(name, "_")
+	if n := len(l); n >= 2 && knownOS[l[n-2]] && knownArch[l[n-1]] {
+		return &constraint.AndExpr{
+			X: &constraint.TagExpr{Tag: l[n-2]},
+			Y: &constraint.TagExpr{Tag: l[n-1]},
+		}
+	} else if n >= 1 && (knownOS[l[n-1]] || knownArch[l[n-1]]) {
+		return &constraint.TagExpr{Tag: l[n-1]}
+	}
+	return nil
+}
+
+// withFilenameConstraint combines the //go:build constraint of a file (which may be empty) with
+// the implicit constraint from its file name, returning the constraint for the whole file.
+func withFilenameConstraint(buildConstraints, filename string) string {
+	fx := filenameConstraint(filename)
+	if fx == nil {
+		return buildConstraints
+	}
+	return andConstraint(buildConstraints, fx)
+}
+
+// andConstraint returns the build constraint that's satisfied when both buildConstraints (which
+// may be empty) and y are.
+func andConstraint(buildConstraints string, y constraint.Expr) string {
+	if buildConstraints == "" {
+		return y.String()
+	}
+	x, err := constraint.Parse("//go:build " + buildConstraints)
+	if err != nil {
+		// leave it to warnUnsatisfiableGroups to report the bad constraint
+		return fmt.Sprintf("(%s) && %s", buildConstraints, y)
+	}
+	return (&constraint.AndExpr{X: x, Y: y}).String()
+}
+
+// canonicalConstraint returns a normalized form of the build constraint, so that trivially
+// equivalent expressions like "linux && amd64" and "amd64 && linux" end up in the same import
+// group: chains of && and || are flattened, their operands deduplicated and sorted, and double
+// negations removed.
+//
+// Constraints that can't be parsed are returned unchanged.
+func canonicalConstraint(buildConstraints string) string {
+	if buildConstraints == "" {
+		return ""
+	}
+	x, err := constraint.Parse("//go:build " + buildConstraints)
+	if err != nil {
+		return buildConstraints
+	}
+	return normalizeConstraint(x).String()
+}
+
+func normalizeConstraint(x constraint.Expr) constraint.Expr {
+	switch x := x.(type) {
+	case *constraint.NotExpr:
+		if inner, ok := x.X.(*constraint.NotExpr); ok {
+			return normalizeConstraint(inner.X)
+		}
+		return &constraint.NotExpr{X: normalizeConstraint(x.X)}
+	case *constraint.AndExpr, *constraint.OrExpr:
+		_, isAnd := x.(*constraint.AndExpr)
+
+		// Collect the operands of the whole chain of the same operator
+		var operands []constraint.Expr
+		var collect func(x constraint.Expr)
+		collect = func(x constraint.Expr) {
+			switch y := x.(type) {
+			case *constraint.AndExpr:
+				if isAnd {
+					collect(y.X)
+					collect(y.Y)
+					return
+				}
+			case *constraint.OrExpr:
+				if !isAnd {
+					collect(y.X)
+					collect(y.Y)
+					return
+				}
+			}
+			operands = append(operands, normalizeConstraint(x))
+		}
+		collect(x)
+
+		slices.SortFunc(operands, func(a, b constraint.Expr) int {
+			return strings.Compare(a.String(), b.String())
+		})
+		operands = slices.CompactFunc(operands, func(a, b constraint.Expr) bool {
+			return a.String() == b.String()
+		})
+
+		result := operands[0]
+		for _, y := range operands[1:] {
+			if isAnd {
+				result = &constraint.AndExpr{X: result, Y: y}
+			} else {
+				result = &constraint.OrExpr{X: result, Y: y}
+			}
+		}
+		return result
+	default:
+		return x
+	}
+}
+
+// equivalentConstraints returns whether the two build constraints are true for exactly the same
+// sets of tags. Constraints that can't be parsed, or that use too many tags to check every
+// combination, are only equivalent if they're the same string.
+func equivalentConstraints(a, b string) bool {
+	if a == b {
+		return true
+	} else if a == "" || b == "" {
+		return false
+	}
+	x, errx := constraint.Parse("//go:build " + a)
+	y, erry := constraint.Parse("//go:build " + b)
+	if errx != nil || erry != nil {
+		return false
+	}
+
+	tags := constraintTags(x)
+	for _, tag := range constraintTags(y) {
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) > maxFreeTags {
+		return false
+	}
+
+	for set := 0; set < 1<<len(tags); set++ {
+		hasTag := func(tag string) bool {
+			return set&(1<<slices.Index(tags, tag)) != 0
+		}
+		if x.Eval(hasTag) != y.Eval(hasTag) {
+			return false
+		}
+	}
+	return true
+}
+
+// ignoreBuildTag is the tag conventionally used to exclude a file from all builds
+const ignoreBuildTag = "ignore"
+
+// requiresTag returns whether the build constraint can only be satisfied when the tag is set, like
+// "ignore" or "ignore && linux" for the "ignore" tag.
+func requiresTag(buildConstraints string, tag string) bool {
+	if buildConstraints == "" {
+		return false
+	}
+	x, err := constraint.Parse("//go:build " + buildConstraints)
+	if err != nil {
+		return false
+	}
+	var requires func(x constraint.Expr) bool
+	requires = func(x constraint.Expr) bool {
+		switch x := x.(type) {
+		case *constraint.TagExpr:
+			return x.Tag == tag
+		case *constraint.AndExpr:
+			return requires(x.X) || requires(x.Y)
+		default:
+			return false
+		}
+	}
+	return requires(x)
+}
+
+// buildTarget is a single build configuration that build constraints can be evaluated against,
+// like go/build does for the files of a package.
+type buildTarget struct {
+	goos, goarch string
+	tags         []string
+	cgo          bool
+}
+
+// newBuildTarget returns the build configuration for the given GOOS, GOARCH and comma-separated
+// build tags, where an empty GOOS or GOARCH means the default for the go command.
+//
+// Like the go command, cgo is only enabled by default when building for the host, unless
+// CGO_ENABLED says otherwise.
+func newBuildTarget(goos, goarch, tags string) *buildTarget {
+	t := &buildTarget{goos: goos, goarch: goarch}
+	if t.goos == "" {
+		t.goos = build.Default.GOOS
+	}
+	if t.goarch == "" {
+		t.goarch = build.Default.GOARCH
+	}
+	t.tags = strings.FieldsFunc(tags, func(r rune) bool { return r == ',' || r == ' ' })
+
+	switch os.Getenv("CGO_ENABLED") {
+	case "1":
+		t.cgo = true
+	case "0":
+		t.cgo = false
+	default:
+		t.cgo = build.Default.CgoEnabled && t.goos == runtime.GOOS && t.goarch == runtime.GOARCH
+	}
+	return t
+}
+
+// matches returns whether a file with the build constraints is built for the target. A nil target
+// matches everything, and so do constraints that can't be parsed.
+func (t *buildTarget) matches(buildConstraints string) bool {
+	if t == nil || buildConstraints == "" {
+		return true
+	}
+	x, err := constraint.Parse("//go:build " + buildConstraints)
+	if err != nil {
+		// leave it to warnUnsatisfiableGroups to report the bad constraint
+		return true
+	}
+	return x.Eval(func(tag string) bool {
+		switch {
+		case tag == t.goarch || matchOSTag(tag, t.goos):
+			return true
+		case tag == "cgo":
+			return t.cgo
+		case tag == runtime.Compiler:
+			return true
+		}
+		return slices.Contains(t.tags, tag) ||
+			slices.Contains(build.Default.ReleaseTags, tag) ||
+			slices.Contains(build.Default.ToolTags, tag)
+	})
+}
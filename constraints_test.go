@@ -0,0 +1,108 @@
+package main
+
+import "testing"
+
+func TestFilenameConstraint(t *testing.T) {
+	tests := []struct {
+		filename string
+		want     string // "" for no constraint
+	}{
+		{"main.go", ""},
+		{"linux.go", ""},
+		{"amd64.go", ""},
+		{"file_linux.go", "linux"},
+		{"file_amd64.go", "amd64"},
+		{"file_linux_amd64.go", "linux && amd64"},
+		{"file_linux_test.go", "linux"},
+		{"file_linux_amd64_test.go", "linux && amd64"},
+		{"file_test.go", ""},
+		{"file_amd64_linux.go", "linux"},
+		{"file_foo.go", ""},
+		{"x_windows_arm64.s", "windows && arm64"},
+		{"_linux.go", "linux"},
+	}
+	for _, tt := range tests {
+		x := filenameConstraint(tt.filename)
+		got := ""
+		if x != nil {
+			got = x.String()
+		}
+		if got != tt.want {
+			t.Errorf("filenameConstraint(%q) = %q, want %q", tt.filename, got, tt.want)
+		}
+	}
+}
+
+func TestWithFilenameConstraint(t *testing.T) {
+	tests := []struct {
+		buildConstraints, filename string
+		want                       string
+	}{
+		{"", "file.go", ""},
+		{"cgo", "file.go", "cgo"},
+		{"", "file_linux.go", "linux"},
+		{"cgo", "file_linux.go", "cgo && linux"},
+		{"cgo || netgo", "file_linux_arm64.go", "(cgo || netgo) && linux && arm64"},
+	}
+	for _, tt := range tests {
+		if got := withFilenameConstraint(tt.buildConstraints, tt.filename); got != tt.want {
+			t.Errorf("withFilenameConstraint(%q, %q) = %q, want %q", tt.buildConstraints, tt.filename, got, tt.want)
+		}
+	}
+}
+
+func TestCanonicalConstraint(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"linux", "linux"},
+		{"linux && amd64", "amd64 && linux"},
+		{"amd64 && linux", "amd64 && linux"},
+		{"linux && linux", "linux"},
+		{"(linux && amd64) && cgo", "amd64 && cgo && linux"},
+		{"linux && (amd64 && cgo)", "amd64 && cgo && linux"},
+		{"windows || linux || darwin", "darwin || linux || windows"},
+		{"!(!linux)", "linux"},
+		{"!(!(linux && amd64))", "amd64 && linux"},
+		{"!!linux", "!!linux"}, // not allowed by the parser
+		{"!(amd64 && linux)", "!(amd64 && linux)"},
+		{"!(linux && amd64)", "!(amd64 && linux)"},
+		{"(linux || darwin) && (amd64 || arm64)", "(amd64 || arm64) && (darwin || linux)"},
+		{"linux && (darwin || linux)", "(darwin || linux) && linux"},
+		{"not a constraint &&", "not a constraint &&"},
+	}
+	for _, tt := range tests {
+		if got := canonicalConstraint(tt.in); got != tt.want {
+			t.Errorf("canonicalConstraint(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestEquivalentConstraints(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"", "", true},
+		{"linux", "", false},
+		{"linux", "linux", true},
+		{"linux", "darwin", false},
+		{"linux && amd64", "amd64 && linux", true},
+		{"!(linux || darwin)", "!linux && !darwin", true},
+		{"linux && (amd64 || arm64)", "(linux && amd64) || (linux && arm64)", true},
+		{"linux || (linux && cgo)", "linux", true},
+		{"linux && cgo", "linux", false},
+		{"bad &&", "bad &&", true},
+		{"bad &&", "linux", false},
+		{"t0 && t1 && t2 && t3 && t4 && t5 && t6 && t7 && t8 && t9 && t10 && t11 && t12", "t12 && t11 && t10 && t9 && t8 && t7 && t6 && t5 && t4 && t3 && t2 && t1 && t0", false},
+	}
+	for _, tt := range tests {
+		if got := equivalentConstraints(tt.a, tt.b); got != tt.want {
+			t.Errorf("equivalentConstraints(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+		if got := equivalentConstraints(tt.b, tt.a); got != tt.want {
+			t.Errorf("equivalentConstraints(%q, %q) = %v, want %v", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,1159 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/build"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+)
+
+type cookOptions struct {
+	mod           string
+	tags          string
+	withDebug     bool
+	inheritGoEnv  string
+	cacheProg     string
+	installTools  string
+	verifyTargets string
+	optimizeLayer bool
+	gowork        string
+	platform      string
+	strictEnv     bool
+	inPlace       bool
+	force         bool
+	perPackage    bool
+	jobs          int
+
+	// ldflags, gcflags, asmflags, buildmode, and trimpath are passed on to 'go build', see
+	// passthroughFlags
+	ldflags, gcflags, asmflags, buildmode string
+	trimpath                              bool
+
+	// race and cover additionally build everything with -race and -cover
+	race, cover bool
+	// withStd also builds the whole standard library
+	withStd bool
+	// withVet also runs 'go vet', to cache the facts of the dependencies
+	withVet bool
+	// goflags are added to GOFLAGS for every go command
+	goflags string
+	// goproxy, goprivate, and gonosumdb, if not empty, set GOPROXY, GOPRIVATE, and GONOSUMDB
+	goproxy, goprivate, gonosumdb string
+	// netrc and gitCredentials are the files with credentials for private modules, see
+	// credentialsEnv
+	netrc, gitCredentials string
+	// buildArgs are the arguments after '--', passed verbatim to every 'go build' and 'go install'
+	buildArgs    []string
+	keepGoing    bool
+	strict       bool
+	downloadOnly bool
+	buildOnly    bool
+	offline      bool
+	retries      int
+	// verbose reports every package that 'go build' compiles, and quiet hides everything but
+	// errors
+	verbose, quiet bool
+	// showStats reports the stats of the cook at the end, and statsFile, if not empty, is where
+	// they're written as JSON
+	showStats bool
+	statsFile string
+
+	// goEnvFile, if not empty, is the GOENV file that go commands should use instead of the user's.
+	goEnvFile string
+	// cgo is whether any import group in the recipe needs cgo
+	cgo bool
+	// goWorkFile, if not empty, is the go.work file written for a workspace recipe
+	goWorkFile string
+	// goos and goarch, if not empty, are the GOOS and GOARCH to build for, from -goos and -goarch,
+	// -platform, or defaultCookTarget
+	goos, goarch string
+	// goTmpDir, if not empty, is the GOTMPDIR of the go commands, which is removed after the cook
+	// with -optimize-layer
+	goTmpDir string
+	// noNetwork is whether go commands must only use the module cache, not download anything:
+	// with -build-only or -offline
+	noNetwork bool
+	// stats collects the compile times of packages, for the stats of the cook
+	stats *statsCollector
+}
+
+// forceCgo returns whether go commands need CGO_ENABLED=1, because the go command would otherwise
+// silently disable cgo if it can't find a C compiler, skipping the cgo import groups. An explicit
+// CGO_ENABLED=0 is still respected, and so is the go command's default of disabling cgo when cross
+// compiling, which the final build gets as well.
+func (opts cookOptions) forceCgo() bool {
+	if _, ok := os.LookupEnv("CGO_ENABLED"); ok || !opts.cgo {
+		return false
+	}
+	goos, goarch := cmp.Or(opts.goos, build.Default.GOOS), cmp.Or(opts.goarch, build.Default.GOARCH)
+	return goos == runtime.GOOS && goarch == runtime.GOARCH
+}
+
+// cookTarget returns the build configuration of the cook's go commands, to match the build
+// constraints of import groups against
+func (opts cookOptions) cookTarget() *buildTarget {
+	target := newBuildTarget(opts.goos, opts.goarch, opts.tags)
+	if opts.forceCgo() {
+		target.cgo = true
+	}
+	return target
+}
+
+// goCommand returns an exec.Cmd for running the go command with the given arguments, in the
+// environment determined by the cook options.
+func (opts cookOptions) goCommand(args ...string) *exec.Cmd {
+	cmd := exec.Command("go", args...)
+	cmd.Env = os.Environ()
+	if opts.goEnvFile != "" {
+		cmd.Env = append(cmd.Env, "GOENV="+opts.goEnvFile)
+	}
+	if opts.goWorkFile != "" {
+		cmd.Env = append(cmd.Env, "GOWORK="+opts.goWorkFile)
+	} else if opts.gowork == "off" {
+		cmd.Env = append(cmd.Env, "GOWORK=off")
+	}
+	if opts.goTmpDir != "" {
+		cmd.Env = append(cmd.Env, "GOTMPDIR="+opts.goTmpDir)
+	}
+	if opts.goos != "" {
+		cmd.Env = append(cmd.Env, "GOOS="+opts.goos)
+	}
+	if opts.goarch != "" {
+		cmd.Env = append(cmd.Env, "GOARCH="+opts.goarch)
+	}
+	if opts.forceCgo() {
+		cmd.Env = append(cmd.Env, "CGO_ENABLED=1")
+	}
+	if opts.goproxy != "" {
+		cmd.Env = append(cmd.Env, "GOPROXY="+opts.goproxy)
+	}
+	if opts.goprivate != "" {
+		cmd.Env = append(cmd.Env, "GOPRIVATE="+opts.goprivate)
+	}
+	if opts.gonosumdb != "" {
+		cmd.Env = append(cmd.Env, "GONOSUMDB="+opts.gonosumdb)
+	}
+	cmd.Env = append(cmd.Env, opts.credentialsEnv()...)
+	if opts.noNetwork {
+		// Modules that are already in the module cache can still be used
+		cmd.Env = append(cmd.Env, "GOPROXY=off")
+	}
+	if opts.goflags != "" {
+		cmd.Env = append(cmd.Env, "GOFLAGS="+strings.TrimSpace(os.Getenv("GOFLAGS")+" "+opts.goflags))
+	}
+	if opts.cacheProg != "" {
+		// The cache program is started by the go command itself, once per invocation, and from
+		// then on decides where compiled packages are fetched from and stored.
+		cmd.Env = append(cmd.Env, "GOCACHEPROG="+opts.cacheProg)
+	}
+	cmd.Stdout = diag.output()
+	cmd.Stderr = os.Stderr
+	if opts.quiet {
+		cmd.Stdout = io.Discard
+		cmd.Stderr = &quietWriter{w: os.Stderr}
+	}
+	return cmd
+}
+
+func runCook(recipePath string, opts cookOptions) error {
+	recipeJSON, err := readRecipeFile(recipePath)
+	if err != nil {
+		return fmt.Errorf("could not read recipe at %s: %w", recipePath, err)
+	}
+	var r recipe
+	if err := json.Unmarshal(recipeJSON, &r); err != nil {
+		return fmt.Errorf("could not unmarshal recipe JSON at %s: %w", recipePath, err)
+	}
+	// Fields are only ever added in a compatible way within a version, so anything older works
+	if r.Version > recipeVersion {
+		return fmt.Errorf("error: Recipe at %s has format version %d, but this go-chef only supports up to version %d. Cook with the same go-chef version that prepared it", recipePath, r.Version, recipeVersion)
+	}
+
+	if err := opts.useRecordedFlags(r.BuildFlags); err != nil {
+		return err
+	}
+
+	defaultOS, defaultArch := defaultCookTarget()
+	opts.goos = cmp.Or(opts.goos, defaultOS)
+	opts.goarch = cmp.Or(opts.goarch, defaultArch)
+	if r.hasPlatforms() {
+		platform := opts.platform
+		if platform == "" {
+			// Whatever the go command builds for by default, like the final build: the host
+			// platform, unless GOOS and GOARCH say otherwise
+			env, err := readGoEnv(opts.goCommand(), []string{"GOOS", "GOARCH"})
+			if err != nil {
+				return err
+			}
+			platform = env["GOOS"] + "/" + env["GOARCH"]
+		}
+		if opts.goos, opts.goarch, err = parsePlatform(platform); err != nil {
+			return err
+		}
+		if err := r.selectPlatform(platform); err != nil {
+			if opts.platform == "" {
+				return fmt.Errorf("%w. Pick one with -platform (or -goos and -goarch, or TARGETOS and TARGETARCH)", err)
+			}
+			return err
+		}
+	} else if opts.platform != "" {
+		return fmt.Errorf("error: Cannot cook for platform %q, recipe wasn't prepared with -platforms", opts.platform)
+	}
+
+	if opts.inheritGoEnv != "all" {
+		goEnvFile, err := writeCookGoEnv(opts.inheritGoEnv)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(goEnvFile)
+		opts.goEnvFile = goEnvFile
+	}
+	opts.cgo = slices.ContainsFunc(r.allImportGroups(), func(g importGroup) bool { return g.Cgo })
+
+	// Everything from the current directory has to be read before switching to the scratch one.
+	// Workspace recipes bring their own go.work.
+	if r.GoWork == "" {
+		if err := checkNoGoWork(".", opts.gowork); err != nil {
+			return err
+		}
+	}
+	modules := r.cookModules()
+	for i := range modules {
+		if err := modules[i].readOmittedGoMod(); err != nil {
+			return err
+		}
+	}
+	if opts.installTools != "" {
+		if opts.installTools, err = filepath.Abs(opts.installTools); err != nil {
+			return fmt.Errorf("could not resolve -install-tools directory: %w", err)
+		}
+	}
+	for _, f := range []struct {
+		path *string
+		flag string
+	}{{&opts.netrc, "-netrc"}, {&opts.gitCredentials, "-git-credentials"}} {
+		if *f.path == "" {
+			continue
+		}
+		if _, err := os.Stat(*f.path); err != nil {
+			return fmt.Errorf("could not read %s file: %w", f.flag, err)
+		}
+		if *f.path, err = filepath.Abs(*f.path); err != nil {
+			return fmt.Errorf("could not resolve %s file: %w", f.flag, err)
+		}
+	}
+
+	if opts.statsFile != "" {
+		if opts.statsFile, err = filepath.Abs(opts.statsFile); err != nil {
+			return fmt.Errorf("could not resolve -stats-file: %w", err)
+		}
+	}
+
+	// With -build-only and -offline, everything must already have been downloaded, e.g. by
+	// -download-only in a previous layer. This also holds for -verify-targets and -optimize-layer.
+	opts.noNetwork = opts.buildOnly || opts.offline
+
+	if opts.optimizeLayer {
+		// The go commands put their temporary go-build* directories in here, so that those left
+		// behind by an interrupted one are removed as well, without touching anyone else's
+		goTmpDir, err := os.MkdirTemp(os.Getenv("GOTMPDIR"), "go-chef-gotmp-*")
+		if err != nil {
+			return fmt.Errorf("could not create GOTMPDIR: %w", err)
+		}
+		defer os.RemoveAll(goTmpDir)
+		opts.goTmpDir = goTmpDir
+	}
+
+	if opts.showStats || opts.statsFile != "" {
+		opts.stats = newStatsCollector(opts)
+	}
+
+	if opts.inPlace {
+		if err := cookRecipe(opts, &r, modules); err != nil {
+			return err
+		}
+	} else if err := inScratchDir(func() error { return cookRecipe(opts, &r, modules) }); err != nil {
+		return err
+	}
+
+	if opts.verifyTargets != "" {
+		err := diag.runPhase("verifyTargets", "", func() error {
+			return verifyTargets(opts, strings.Fields(opts.verifyTargets))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if opts.optimizeLayer {
+		if err := diag.runPhase("optimizeLayer", "", func() error { return optimizeLayer(opts) }); err != nil {
+			return err
+		}
+	}
+
+	if opts.stats == nil {
+		return nil
+	}
+	stats := opts.stats.stats(opts)
+	if opts.showStats {
+		diag.cookDone(stats)
+	}
+	if opts.statsFile != "" {
+		return writeStatsFile(opts.statsFile, stats)
+	}
+	return nil
+}
+
+// inScratchDir runs f in a new temporary directory, which is removed afterwards. Only the module
+// and build caches are shared with the go commands run from the current directory.
+func inScratchDir(f func() error) error {
+	dir, err := os.MkdirTemp("", "go-chef-cook-*")
+	if err != nil {
+		return fmt.Errorf("could not create scratch directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("could not get current directory: %w", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		return fmt.Errorf("could not change to scratch directory: %w", err)
+	}
+	err = f()
+	if cerr := os.Chdir(wd); cerr != nil && err == nil {
+		err = fmt.Errorf("could not change back from scratch directory: %w", cerr)
+	}
+	return err
+}
+
+// cookRecipe builds the modules of the recipe in the current directory. Afterwards -- also when
+// the build fails -- the generated files are removed, and the files that were overwritten are
+// restored.
+func cookRecipe(opts cookOptions, r *recipe, modules []moduleRecipe) (err error) {
+	if err := checkGoVersion(opts, r); err != nil {
+		return err
+	}
+
+	var overwritten []string
+	if r.GoWork != "" {
+		overwritten = append(overwritten, "go.work", "go.work.sum")
+	}
+	for _, m := range modules {
+		dir := filepath.FromSlash(m.Dir)
+		overwritten = append(overwritten, filepath.Join(dir, "go.mod"), filepath.Join(dir, "go.sum"))
+		// Only overwritten with -force
+		existing, err := filepath.Glob(filepath.Join(dir, "chef_*.go"))
+		if err != nil {
+			return err
+		}
+		overwritten = append(overwritten, existing...)
+	}
+	saved, err := saveFiles(overwritten)
+	if err != nil {
+		return err
+	}
+	var goFiles []string
+	defer func() {
+		err = errors.Join(err, cleanupCook(modules, goFiles, saved))
+	}()
+
+	if r.GoWork != "" {
+		if err := os.WriteFile("go.work", []byte(r.GoWork), 0o666); err != nil {
+			return fmt.Errorf("could not write go.work: %w", err)
+		}
+		if r.GoWorkSum != "" {
+			if err := os.WriteFile("go.work.sum", []byte(r.GoWorkSum), 0o666); err != nil {
+				return fmt.Errorf("could not write go.work.sum: %w", err)
+			}
+		}
+		goWorkFile, err := filepath.Abs("go.work")
+		if err != nil {
+			return fmt.Errorf("could not resolve path of go.work: %w", err)
+		}
+		opts.goWorkFile = goWorkFile
+	}
+
+	if err := checkCookEnv(opts, r); err != nil {
+		return err
+	}
+
+	// Write go.mod, go.sum, and generate the .go file(s) for every module, and then run
+	// 'go build -o /dev/null .' in each of them.
+	if opts.mod == "vendor" {
+		if err := checkVendorPresent(r, modules); err != nil {
+			return err
+		}
+	}
+	for _, m := range modules {
+		files, err := writeCookModule(m, opts)
+		goFiles = append(goFiles, files...)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.offline && opts.mod != "vendor" {
+		if err := checkModulesCached(opts, modules); err != nil {
+			return err
+		}
+	} else if !opts.noNetwork && opts.mod != "vendor" {
+		if err := warnMissingCredentials(opts, modules); err != nil {
+			return err
+		}
+	}
+	// Modules are cooked in parallel, each in its own directory, sharing the module and build
+	// caches. With -keep-going, packages that fail to build are reported at the end instead.
+	moduleFailures := make([][]buildFailure, len(modules))
+	err = runParallel(opts.jobs, len(modules), func(i int) error {
+		var err error
+		moduleFailures[i], err = cookModule(opts, modules[i])
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	var failures []buildFailure
+	for _, f := range moduleFailures {
+		failures = append(failures, f...)
+	}
+
+	if opts.withStd {
+		// When cross-compiling, the standard library isn't in the cache yet either. Only the
+		// packages that the dependencies import were built above.
+		err := diag.runPhase("buildStd", "", func() error {
+			for _, extraArgs := range opts.buildVariants() {
+				stdFailures, err := goBuild(opts, ".", append(opts.goBuildFlags(), extraArgs...), []string{"std"})
+				if err != nil {
+					return fmt.Errorf("could not run 'go build std' command: %w", err)
+				}
+				failures = append(failures, stdFailures...)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return reportBuildFailures(opts, failures)
+}
+
+// cookModule builds (or with -download-only, downloads) the dependencies of a module whose files
+// were written by writeCookModule, and installs its tools.
+func cookModule(opts cookOptions, m moduleRecipe) ([]buildFailure, error) {
+	dir := filepath.FromSlash(m.Dir)
+	// Downloading first means that only downloads need to be retried, and not builds as well
+	if !opts.noNetwork && opts.mod != "vendor" {
+		if err := diag.runPhase("download", m.Dir, func() error { return downloadModules(opts, dir) }); err != nil {
+			return nil, err
+		}
+	}
+	if opts.downloadOnly {
+		return nil, nil
+	}
+
+	// Either the generated main package is built, or the ones of each import group in parallel, or
+	// with -per-package, the packages themselves
+	var pkgs, groupDirs []string
+	if opts.perPackage {
+		pkgs = cookPackages(opts, m)
+	}
+	for _, g := range cookGroups(opts, m) {
+		groupDirs = append(groupDirs, cookGroupDir(g))
+	}
+	requiredModules := m.allRequiredModules()
+	if len(requiredModules) != 0 && buildModeNeedsMain(opts.buildmode) {
+		return nil, fmt.Errorf("error: Cannot cook with -buildmode=%s, which needs a main package, for a recipe prepared with -granularity=module", opts.buildmode)
+	}
+	var failures []buildFailure
+	err := diag.runPhase("build", m.Dir, func() error {
+		for _, extraArgs := range opts.buildVariants() {
+			buildFailures, err := runGoBuild(opts, dir, pkgs, groupDirs, requiredModules, extraArgs...)
+			if err != nil {
+				return err
+			}
+			failures = append(failures, buildFailures...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if opts.withVet {
+		if err := diag.runPhase("vet", m.Dir, func() error { return runGoVet(opts, dir, pkgs, groupDirs) }); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(m.Tools) != 0 {
+		if err := diag.runPhase("tools", m.Dir, func() error { return installTools(opts, dir, m.Tools) }); err != nil {
+			return nil, err
+		}
+	}
+	return failures, nil
+}
+
+// checkModulesCached fails if any module needed by the modules to cook isn't in the module cache,
+// which 'go mod download' checks without downloading anything when offline. That way, -offline
+// reports what's missing up front, instead of in the middle of building.
+func checkModulesCached(opts cookOptions, modules []moduleRecipe) error {
+	for _, m := range modules {
+		cmd := opts.goCommand("mod", "download")
+		cmd.Dir = filepath.FromSlash(m.Dir)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("error: Cannot cook with -offline, because the module cache is missing modules (download them first, e.g. with -download-only):\n%s", strings.TrimSpace(stderr.String()))
+		}
+	}
+	return nil
+}
+
+// runGoVet runs 'go vet' on the generated package in dir (or the ones in groupDirs, or with
+// -per-package, on pkgs), which caches the analysis facts of all its dependencies for later
+// 'go vet' runs.
+//
+// What vet reports about the packages themselves with -per-package is of no use here, so it's
+// discarded. With -keep-going, a failed vet is only a warning.
+func runGoVet(opts cookOptions, dir string, pkgs []string, groupDirs []string) error {
+	switch {
+	case opts.perPackage:
+		if len(pkgs) == 0 {
+			return nil
+		}
+	case len(groupDirs) != 0:
+		pkgs = nil
+		for _, d := range groupDirs {
+			pkgs = append(pkgs, "./"+d)
+		}
+	default:
+		pkgs = []string{"."}
+	}
+	args := append([]string{"vet"}, opts.goBuildFlags()...)
+	cmd := opts.goCommand(append(args, pkgs...)...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if err == nil || (opts.perPackage && errors.As(err, &exitErr) && exitErr.ExitCode() == 1) {
+		// exit status 1 means that vet reported something
+		return nil
+	}
+	if opts.keepGoing {
+		warnf("could not run 'go vet':\n\t%s", strings.ReplaceAll(strings.TrimSpace(stderr.String()), "\n", "\n\t"))
+		return nil
+	}
+	os.Stderr.Write(stderr.Bytes())
+	return fmt.Errorf("could not run 'go vet' command: %w", err)
+}
+
+// buildVariants returns the extra 'go build' flags of each build that cook runs: first the normal
+// build, then the debug build for -with-debug, and the instrumented build for -race and -cover
+// (with both, like 'go test -race -cover'). Each of them is a separate set of entries in the build
+// cache, so every variant compiles everything again.
+func (opts cookOptions) buildVariants() [][]string {
+	variants := [][]string{nil}
+	if opts.withDebug {
+		variants = append(variants, []string{"-gcflags=all=-N -l"})
+	}
+	var instrumented []string
+	if opts.race {
+		instrumented = append(instrumented, "-race")
+	}
+	if opts.cover {
+		instrumented = append(instrumented, "-cover")
+	}
+	if len(instrumented) != 0 {
+		variants = append(variants, instrumented)
+	}
+	return variants
+}
+
+// runParallel calls f for every i from 0 to n-1, with up to jobs calls running at the same time,
+// and returns all their errors.
+func runParallel(jobs, n int, f func(i int) error) error {
+	errs := make([]error, n)
+	sem := make(chan struct{}, max(jobs, 1))
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			errs[i] = f(i)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// savedFile is the contents of a file from before cook overwrote it
+type savedFile struct {
+	path     string
+	contents []byte
+	mode     fs.FileMode
+}
+
+// saveFiles reads those of the files at paths that exist, so that cleanupCook can put them back.
+func saveFiles(paths []string) ([]savedFile, error) {
+	var saved []savedFile
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("could not stat %s: %w", path, err)
+		}
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read %s: %w", path, err)
+		}
+		saved = append(saved, savedFile{path: path, contents: contents, mode: info.Mode().Perm()})
+	}
+	return saved, nil
+}
+
+// cleanupCook removes the generated files and restores the saved ones. go.mod and go.sum files that
+// didn't exist before are left as written by cook, except that go.mod no longer points to the stubs
+// of locally replaced modules, which are gone.
+func cleanupCook(modules []moduleRecipe, goFiles []string, saved []savedFile) error {
+	var errs []error
+	for _, filename := range goFiles {
+		if err := os.RemoveAll(filename); err != nil {
+			errs = append(errs, fmt.Errorf("could not remove %s: %w", filename, err))
+		}
+	}
+	for _, m := range modules {
+		if len(m.LocalReplaces) == 0 {
+			continue
+		}
+		path := filepath.Join(filepath.FromSlash(m.Dir), "go.mod")
+		if _, err := os.Stat(path); err != nil {
+			// never got written
+			continue
+		}
+		if err := os.WriteFile(path, []byte(m.GoMod), 0o666); err != nil {
+			errs = append(errs, fmt.Errorf("could not rewrite %s: %w", path, err))
+		}
+	}
+	for _, f := range saved {
+		if err := os.WriteFile(f.path, f.contents, f.mode); err != nil {
+			errs = append(errs, fmt.Errorf("could not restore %s: %w", f.path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// cookModules returns the modules to cook for the recipe: either all the modules of a workspace, or
+// just the one in the current directory
+func (r *recipe) cookModules() []moduleRecipe {
+	if len(r.Modules) != 0 {
+		return r.Modules
+	}
+	return []moduleRecipe{{Dir: ".", recipe: *r}}
+}
+
+// readOmittedGoMod reads go.mod and go.sum from the module's directory if they were left out of the
+// recipe with -omit-gomod, so that they're written back unchanged.
+func (m *moduleRecipe) readOmittedGoMod() error {
+	if !m.OmitGoMod {
+		return nil
+	}
+	dir := filepath.FromSlash(m.Dir)
+	modContents, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("error: Recipe was prepared with -omit-gomod, so %s must be copied in before cooking", filepath.Join(dir, "go.mod"))
+	} else if err != nil {
+		return fmt.Errorf("could not read go.mod: %w", err)
+	}
+	// Without any dependencies, there's no go.sum
+	sumContents, err := os.ReadFile(filepath.Join(dir, "go.sum"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("could not read go.sum: %w", err)
+	}
+	m.GoMod, m.GoSum = string(modContents), string(sumContents)
+	return nil
+}
+
+// writeCookModule writes go.mod, go.sum and the generated files for the module, returning the
+// paths of the generated .go files (and stub directories) so that they can be removed afterwards.
+// The paths written so far are returned on errors as well.
+//
+// Existing files with the names of generated ones are only overwritten with -force. With
+// -per-package, no .go files are generated at all.
+func writeCookModule(m moduleRecipe, opts cookOptions) (goFiles []string, _ error) {
+	dir := filepath.FromSlash(m.Dir)
+	if !filepath.IsLocal(dir) {
+		return nil, fmt.Errorf("could not cook module in %q: directory is outside of the current directory", m.Dir)
+	}
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		return nil, fmt.Errorf("could not create directory for module %s: %w", m.Dir, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte(m.GoMod), 0o666); err != nil {
+		return nil, fmt.Errorf("could not write go.mod: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "go.sum"), []byte(m.GoSum), 0o666); err != nil {
+		return nil, fmt.Errorf("could not write go.sum: %w", err)
+	}
+	if len(m.LocalReplaces) != 0 {
+		stubsDir := filepath.Join(dir, cookReplacesDir)
+		if err := checkNotExist(stubsDir, opts.force); err != nil {
+			return nil, err
+		}
+		goFiles = append(goFiles, stubsDir)
+		if err := writeLocalReplaceStubs(dir, m); err != nil {
+			return goFiles, err
+		}
+	}
+	if opts.perPackage {
+		return goFiles, nil
+	}
+	if groups := cookGroups(opts, m); groups != nil {
+		for _, g := range groups {
+			groupDir := filepath.Join(dir, cookGroupDir(g))
+			if err := checkNotExist(groupDir, opts.force); err != nil {
+				return goFiles, err
+			}
+			goFiles = append(goFiles, groupDir)
+			// With -force, nothing that was there may end up in the package
+			if err := os.RemoveAll(groupDir); err != nil {
+				return goFiles, fmt.Errorf("could not remove %s: %w", groupDir, err)
+			}
+			if err := os.Mkdir(groupDir, 0o777); err != nil {
+				return goFiles, fmt.Errorf("could not create directory %s: %w", groupDir, err)
+			}
+			path := filepath.Join(groupDir, cookMainFile)
+			if err := os.WriteFile(path, cookGroupContent(g), 0o666); err != nil {
+				return goFiles, fmt.Errorf("could not write %s: %w", path, err)
+			}
+		}
+		return goFiles, nil
+	}
+
+	hasMain := false
+	for _, g := range m.cookImportGroups() {
+		filename := cookFileName(g.BuildConstraints)
+		if filename == cookMainFile {
+			hasMain = true
+		}
+		path := filepath.Join(dir, filename)
+		if err := checkNotExist(path, opts.force); err != nil {
+			return goFiles, err
+		}
+		goFiles = append(goFiles, path)
+		if err := os.WriteFile(path, cookFileContent(g), 0o666); err != nil {
+			return goFiles, fmt.Errorf("could not write %s: %w", path, err)
+		}
+	}
+	// There may not be an unconstrained group, but we still need exactly one file that's always
+	// built and declares func main.
+	if !hasMain {
+		path := filepath.Join(dir, cookMainFile)
+		if err := checkNotExist(path, opts.force); err != nil {
+			return goFiles, err
+		}
+		goFiles = append(goFiles, path)
+		if err := os.WriteFile(path, cookFileContent(importGroup{}), 0o666); err != nil {
+			return goFiles, fmt.Errorf("could not write %s: %w", path, err)
+		}
+	}
+	return goFiles, nil
+}
+
+// installTools runs 'go install' for the tools captured in the recipe -- from tool directives in
+// go.mod, tools.go files, or //go:generate directives -- so that everything they need is in the
+// build cache by the time 'go tool', 'go run' or 'go generate' runs them.
+//
+// The binaries are put in opts.installTools, so that later go:generate or lint steps in the
+// Dockerfile can use them without recompiling. Without -install-tools, they're discarded.
+func installTools(opts cookOptions, dir string, tools []string) error {
+	if len(tools) == 0 {
+		return nil
+	}
+	var binDir string
+	if opts.installTools != "" {
+		binDir = opts.installTools
+	} else {
+		tmpDir, err := os.MkdirTemp("", "go-chef-tools-*")
+		if err != nil {
+			return fmt.Errorf("could not create output directory for tools: %w", err)
+		}
+		defer os.RemoveAll(tmpDir)
+		binDir = tmpDir
+	}
+
+	var pkgs []string
+	var versioned []string
+	for _, tool := range tools {
+		if strings.Contains(tool, "@") {
+			versioned = append(versioned, tool)
+		} else {
+			pkgs = append(pkgs, tool)
+		}
+	}
+
+	var cmds []*exec.Cmd
+	if len(pkgs) != 0 {
+		args := append([]string{"install"}, opts.goBuildFlags()...)
+		cmds = append(cmds, opts.goCommand(append(args, pkgs...)...))
+	}
+	// 'go install pkg@version' ignores the current module, so each one is separate, and -mod
+	// doesn't apply. Their modules aren't in go.mod, so 'go mod download' doesn't fetch them, and
+	// they're downloaded here even with -build-only (but not with -offline).
+	online := opts
+	online.noNetwork = opts.offline
+	for _, tool := range versioned {
+		args := []string{"install"}
+		if opts.tags != "" {
+			args = append(args, "-tags", opts.tags)
+		}
+		cmds = append(cmds, online.goCommand(append(args, tool)...))
+	}
+	for _, cmd := range cmds {
+		cmd.Dir = dir
+		cmd.Env = append(cmd.Env, "GOBIN="+binDir)
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("could not run 'go install' command for tools: %w", err)
+		}
+	}
+	return nil
+}
+
+// verifyTargets builds the real target packages -- whose source must be in the current directory
+// -- and reports how many of the dependencies they needed were already in the build cache, which
+// is a direct measure of how effective the recipe was.
+func verifyTargets(opts cookOptions, targets []string) error {
+	// All the packages the targets depend on, excluding the standard library and the packages in
+	// the module itself.
+	listArgs := append([]string{"list", "-deps", "-f", "{{if and (not .Standard) .Module}}{{if not .Module.Main}}{{.ImportPath}}{{end}}{{end}}"}, opts.buildFlags()...)
+	listCmd := opts.goCommand(append(listArgs, targets...)...)
+	var listOut bytes.Buffer
+	listCmd.Stdout = &listOut
+	if err := listCmd.Run(); err != nil {
+		return fmt.Errorf("could not list dependencies of -verify-targets: %w", err)
+	}
+	deps := strings.Fields(listOut.String())
+
+	// 'go build -v' prints the name of every package that's actually compiled -- i.e., every
+	// package that wasn't a cache hit.
+	outDir, err := os.MkdirTemp("", "go-chef-verify-*")
+	if err != nil {
+		return fmt.Errorf("could not create output directory for -verify-targets: %w", err)
+	}
+	defer os.RemoveAll(outDir)
+	buildArgs := append([]string{"build", "-v", "-o", outDir + string(filepath.Separator)}, opts.goBuildFlags()...)
+	buildCmd := opts.goCommand(append(buildArgs, targets...)...)
+	var buildOut bytes.Buffer
+	buildCmd.Stderr = &buildOut
+	if err := buildCmd.Run(); err != nil {
+		os.Stderr.Write(buildOut.Bytes())
+		return fmt.Errorf("could not build -verify-targets: %w", err)
+	}
+	compiled := make(map[string]bool)
+	for _, line := range strings.Split(buildOut.String(), "\n") {
+		compiled[strings.TrimSpace(line)] = true
+	}
+
+	var misses []string
+	for _, pkg := range deps {
+		if compiled[pkg] {
+			misses = append(misses, pkg)
+		}
+	}
+	diag.verified(deps, misses)
+	return nil
+}
+
+// cookImportGroups returns the import groups to cook for the module, including its test imports.
+// The dependencies of locally replaced modules are cooked with the module as well.
+func (m *moduleRecipe) cookImportGroups() []importGroup {
+	groups := [][]importGroup{m.ImportGroups, m.TestImportGroups}
+	for _, lr := range m.LocalReplaces {
+		groups = append(groups, lr.ImportGroups)
+	}
+	return mergeImportGroups(groups...)
+}
+
+// mergeImportGroups combines the packages of import groups with the same build constraints
+func mergeImportGroups(groups ...[]importGroup) []importGroup {
+	var merged []importGroup
+	for _, gs := range groups {
+		for _, g := range gs {
+			i := slices.IndexFunc(merged, func(m importGroup) bool {
+				return m.BuildConstraints == g.BuildConstraints
+			})
+			if i == -1 {
+				merged = append(merged, importGroup{BuildConstraints: g.BuildConstraints})
+				i = len(merged) - 1
+			}
+			merged[i].Cgo = merged[i].Cgo || g.Cgo
+			for _, pkg := range g.Packages {
+				if !slices.Contains(merged[i].Packages, pkg) {
+					merged[i].Packages = append(merged[i].Packages, pkg)
+				}
+			}
+		}
+	}
+	return merged
+}
+
+// checkNotExist returns an error if path exists, unless force is set, so that cook doesn't overwrite
+// a file of the same name as a generated one.
+func checkNotExist(path string, force bool) error {
+	if force {
+		return nil
+	}
+	if _, err := os.Lstat(path); err == nil {
+		return fmt.Errorf("could not write %s: it already exists (pass -force to overwrite it)", path)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("could not stat %s: %w", path, err)
+	}
+	return nil
+}
+
+// cookMainFile is the generated file for the import group without build constraints. It's always
+// written, because it's also where func main is declared.
+const cookMainFile = "chef_main.go"
+
+// cookFileName returns the name of the file generated for an import group with the given build
+// constraints.
+//
+// The name depends only on the constraint expression -- not on the group's position in the recipe
+// -- so adding or reordering groups doesn't shuffle names around, and each file in 'go build'
+// output maps directly back to its group. For example, "linux && amd64" is written to
+// chef_linux_and_amd64_<hash>.go. The hash keeps names unique (e.g. "linux" vs "!linux") and,
+// because it's always last, keeps the name from ending in something like "_linux.go", which the go
+// command would treat as an implicit constraint of its own.
+func cookFileName(buildConstraints string) string {
+	if buildConstraints == "" {
+		return cookMainFile
+	}
+
+	// Spell out operators so the name stays readable
+	r := strings.NewReplacer("!", " not ", "&&", " and ", "||", " or ")
+	words := strings.FieldsFunc(r.Replace(buildConstraints), func(c rune) bool {
+		return !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9')
+	})
+	readable := strings.Join(words, "_")
+	const maxReadableLen = 48
+	if len(readable) > maxReadableLen {
+		readable = strings.TrimSuffix(readable[:maxReadableLen], "_")
+	}
+
+	sum := sha256.Sum256([]byte(buildConstraints))
+	return fmt.Sprintf("chef_%s_%x.go", readable, sum[:4])
+}
+
+// cookFileContent returns the generated Go source for the import group, blank-importing all of its
+// packages.
+func cookFileContent(g importGroup) []byte {
+	var content []byte
+	if g.BuildConstraints != "" {
+		content = append(content, []byte(fmt.Sprintf("//go:build %s\n\n", g.BuildConstraints))...)
+	}
+
+	content = append(content, []byte("package main\n")...)
+	if len(g.Packages) != 0 {
+		content = append(content, []byte("\nimport (\n")...)
+		for _, imp := range g.Packages {
+			content = append(content, []byte(fmt.Sprintf("\t_ %q\n", imp))...)
+		}
+		content = append(content, []byte(")\n")...)
+	}
+	if g.BuildConstraints == "" {
+		content = append(content, []byte("\nfunc main() {}\n")...)
+	}
+	return content
+}
+
+// buildFlags returns the flags shared by all go commands that load or build packages
+func (opts cookOptions) buildFlags() []string {
+	// Always pass -mod explicitly, so that the result doesn't depend on whatever GOFLAGS happens to
+	// be set in the base image.
+	flags := []string{"-mod=" + opts.mod}
+	if opts.tags != "" {
+		flags = append(flags, "-tags", opts.tags)
+	}
+	return append(flags, opts.passthroughFlags()...)
+}
+
+// goBuildFlags returns the flags for go commands that build packages: the buildFlags, followed by
+// the arguments given to cook after '--'.
+func (opts cookOptions) goBuildFlags() []string {
+	return append(opts.buildFlags(), opts.buildArgs...)
+}
+
+// passthroughFlags returns the -trimpath, -ldflags, -gcflags, -asmflags, and -buildmode flags for
+// 'go build'. The build cache is keyed on them, so they need to match the final build.
+func (opts cookOptions) passthroughFlags() []string {
+	var flags []string
+	if opts.trimpath {
+		flags = append(flags, "-trimpath")
+	}
+	if opts.ldflags != "" {
+		flags = append(flags, "-ldflags="+opts.ldflags)
+	}
+	if opts.gcflags != "" {
+		flags = append(flags, "-gcflags="+opts.gcflags)
+	}
+	if opts.asmflags != "" {
+		flags = append(flags, "-asmflags="+opts.asmflags)
+	}
+	if opts.buildmode != "" {
+		flags = append(flags, "-buildmode="+opts.buildmode)
+	}
+	return flags
+}
+
+// buildModeNeedsMain returns whether 'go build' only accepts a single main package with the build
+// mode, so that cook can only build the generated one.
+func buildModeNeedsMain(buildmode string) bool {
+	switch buildmode {
+	case "c-archive", "c-shared", "plugin":
+		return true
+	}
+	return false
+}
+
+// useRecordedFlags takes the flags that prepare recorded in the recipe (see passthroughFlags),
+// except for those also given to cook.
+func (opts *cookOptions) useRecordedFlags(recorded []string) error {
+	for _, f := range recorded {
+		name, value, _ := strings.Cut(f, "=")
+		switch name {
+		case "-trimpath":
+			opts.trimpath = true
+		case "-ldflags":
+			opts.ldflags = cmp.Or(opts.ldflags, value)
+		case "-gcflags":
+			opts.gcflags = cmp.Or(opts.gcflags, value)
+		case "-asmflags":
+			opts.asmflags = cmp.Or(opts.asmflags, value)
+		case "-buildmode":
+			opts.buildmode = cmp.Or(opts.buildmode, value)
+		default:
+			return fmt.Errorf("error: Recipe has unsupported build flag %q", f)
+		}
+	}
+	return nil
+}
+
+// runGoBuild runs 'go build' on the generated package in dir (or the ones in groupDirs, or with
+// -per-package, on pkgs), discarding the output binaries, and then on all packages of the required
+// modules, if any.
+func runGoBuild(opts cookOptions, dir string, pkgs []string, groupDirs []string, requiredModules []string, extraArgs ...string) ([]buildFailure, error) {
+	flags := append(opts.goBuildFlags(), extraArgs...)
+	var failures []buildFailure
+	var err error
+	switch {
+	case opts.perPackage:
+		if len(pkgs) != 0 {
+			failures, err = goBuildBatches(opts, dir, flags, pkgs)
+		}
+	case len(groupDirs) != 0:
+		failures, err = goBuildGroups(opts, dir, flags, groupDirs)
+	default:
+		failures, err = goBuild(opts, dir, flags, []string{"."}) // build the module's directory
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not run 'go build' command: %w", err)
+	}
+
+	if len(requiredModules) == 0 {
+		return failures, nil
+	}
+	modulePkgs, err := listBuildablePackages(opts, dir, requiredModules)
+	if err != nil {
+		return nil, err
+	}
+	if len(modulePkgs) == 0 {
+		return failures, nil
+	}
+	moreFailures, err := goBuild(opts, dir, flags, modulePkgs)
+	if err != nil {
+		return nil, fmt.Errorf("could not run 'go build' command for required modules: %w", err)
+	}
+	return append(failures, moreFailures...), nil
+}
+
+// listBuildablePackages returns all packages of the modules that can be built in the current
+// configuration. Modules commonly contain packages that can't be -- e.g. examples with
+// dependencies that aren't in the build list -- and those are skipped.
+func listBuildablePackages(opts cookOptions, dir string, modules []string) ([]string, error) {
+	args := append([]string{"list", "-e", "-f", "{{if not (or .Error .DepsErrors)}}{{.ImportPath}}{{end}}"}, opts.buildFlags()...)
+	for _, mod := range modules {
+		args = append(args, mod+"/...")
+	}
+	cmd := opts.goCommand(args...)
+	cmd.Dir = dir
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("could not list packages of required modules: %w", err)
+	}
+	return strings.Fields(out.String()), nil
+}
+
+// allRequiredModules returns the required modules of the recipe and its locally replaced modules
+func (r *recipe) allRequiredModules() []string {
+	mods := slices.Clone(r.RequiredModules)
+	for _, lr := range r.LocalReplaces {
+		for _, mod := range lr.RequiredModules {
+			if !slices.Contains(mods, mod) {
+				mods = append(mods, mod)
+			}
+		}
+	}
+	return mods
+}
+
+// writeCookGoEnv writes a new GOENV file for cook to use, returning its path.
+//
+// Settings persisted with 'go env -w' differ between developer machines and CI builders, and
+// silently change how dependencies are resolved and compiled. So by default, cook starts from an
+// empty config, copying over only the comma-separated settings in inherit from the user's own.
+func writeCookGoEnv(inherit string) (string, error) {
+	var content []byte
+	if inherit != "" {
+		keys := strings.Split(inherit, ",")
+
+		userFile, err := exec.Command("go", "env", "GOENV").Output()
+		if err != nil {
+			return "", fmt.Errorf("could not find go env config file: %w", err)
+		}
+		userEnv, err := os.ReadFile(strings.TrimSpace(string(userFile)))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("could not read go env config file: %w", err)
+		}
+		for _, line := range strings.Split(string(userEnv), "\n") {
+			key, _, ok := strings.Cut(line, "=")
+			if ok && slices.Contains(keys, strings.TrimSpace(key)) {
+				content = append(content, line...)
+				content = append(content, '\n')
+			}
+		}
+	}
+
+	f, err := os.CreateTemp("", "go-chef-goenv-*")
+	if err != nil {
+		return "", fmt.Errorf("could not create go env config file: %w", err)
+	}
+	_, err = f.Write(content)
+	if err := errors.Join(err, f.Close()); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("could not write go env config file: %w", err)
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// unsetEnv unsets the environment variable for the test, restoring it afterwards
+func unsetEnv(t *testing.T, name string) {
+	t.Helper()
+	if value, ok := os.LookupEnv(name); ok {
+		os.Unsetenv(name)
+		t.Cleanup(func() { os.Setenv(name, value) })
+	}
+}
+
+// fakeGo puts a go command on PATH that only logs the value of GOPROXY and its arguments, one
+// line per run, and returns the log file.
+func fakeGo(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	log := filepath.Join(dir, "go.log")
+	script := "#!/bin/sh\necho \"GOPROXY=$GOPROXY $*\" >> " + log + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "go"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(filepath.ListSeparator)+os.Getenv("PATH"))
+	return log
+}
+
+func TestCookOfflineVerifyTargets(t *testing.T) {
+	log := fakeGo(t)
+	recipePath := filepath.Join(t.TempDir(), "recipe.json")
+	if err := os.WriteFile(recipePath, []byte(`{"importGroups":[],"go.mod":"module example.com/m\n","go.sum":""}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	opts := cookOptions{offline: true, verifyTargets: "./cmd/app", jobs: 1, inheritGoEnv: "all"}
+	if err := runCook(recipePath, opts); err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var verified bool
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if !strings.HasSuffix(line, " ./cmd/app") {
+			continue
+		}
+		verified = true
+		if !strings.HasPrefix(line, "GOPROXY=off ") {
+			t.Errorf("-verify-targets ran 'go %s' with network access", strings.SplitN(line, " ", 2)[1])
+		}
+	}
+	if !verified {
+		t.Fatalf("-verify-targets didn't run go commands, got:\n%s", out)
+	}
+}
+
+func TestGoCommandEnv(t *testing.T) {
+	for _, name := range []string{"CGO_ENABLED", "GOFLAGS"} {
+		unsetEnv(t, name)
+	}
+	tests := []struct {
+		name string
+		opts cookOptions
+		want []string
+	}{
+		{"defaults", cookOptions{}, nil},
+		{"workspace", cookOptions{goWorkFile: "/src/go.work", gowork: "off"}, []string{"GOWORK=/src/go.work"}},
+		{"no workspace", cookOptions{gowork: "off"}, []string{"GOWORK=off"}},
+		{"cross-compiling with cgo groups", cookOptions{goos: "plan9", goarch: "386", cgo: true}, []string{"GOOS=plan9", "GOARCH=386"}},
+		{"cgo groups", cookOptions{cgo: true}, []string{"CGO_ENABLED=1"}},
+		{"private modules", cookOptions{goproxy: "https://proxy.example.com", goprivate: "example.com/private", netrc: "/run/secrets/netrc"}, []string{"GOPROXY=https://proxy.example.com", "GOPRIVATE=example.com/private", "NETRC=/run/secrets/netrc"}},
+		{"no network", cookOptions{goproxy: "https://proxy.example.com", noNetwork: true}, []string{"GOPROXY=https://proxy.example.com", "GOPROXY=off"}},
+		{"-offline alone", cookOptions{offline: true}, nil},
+		{"goflags and GOTMPDIR", cookOptions{goflags: "-buildvcs=false", goTmpDir: "/tmp/go-chef-gotmp-1"}, []string{"GOTMPDIR=/tmp/go-chef-gotmp-1", "GOFLAGS=-buildvcs=false"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Everything cook sets comes after the inherited environment, so that it wins
+			got := tt.opts.goCommand("version").Env[len(os.Environ()):]
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("goCommand() env = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCookFileName(t *testing.T) {
+	tests := []struct {
+		constraints string
+		readable    string
+	}{
+		{"linux", "linux"},
+		{"!linux", "not_linux"},
+		{"linux && amd64", "linux_and_amd64"},
+		{"(darwin || freebsd) && !cgo", "darwin_or_freebsd_and_not_cgo"},
+		{"go1.22", "go1_22"},
+		{"linux && (amd64 || arm64 || riscv64 || ppc64le || s390x || mips64le)", "linux_and_amd64_or_arm64_or_riscv64_or_ppc64le_o"}, // cut off,
+	}
+	if got := cookFileName(""); got != cookMainFile {
+		t.Errorf("cookFileName(\"\") = %q, want %q", got, cookMainFile)
+	}
+	seen := make(map[string]string)
+	for _, tt := range tests {
+		got := cookFileName(tt.constraints)
+		if !regexp.MustCompile(`^chef_` + regexp.QuoteMeta(tt.readable) + `_[0-9a-f]{8}\.go$`).MatchString(got) {
+			t.Errorf("cookFileName(%q) = %q, want chef_%s_<hash>.go", tt.constraints, got, tt.readable)
+		}
+		// The go command mustn't read a constraint of its own into the name
+		if x := filenameConstraint(got); x != nil {
+			t.Errorf("cookFileName(%q) = %q, which has the implicit constraint %s", tt.constraints, got, x)
+		}
+		if other, ok := seen[got]; ok {
+			t.Errorf("cookFileName(%q) = cookFileName(%q) = %q", tt.constraints, other, got)
+		}
+		seen[got] = tt.constraints
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// diag receives the diagnostics reported while running.
+//
+// By default, only warnings are shown, as text on stderr. With -log-format=json (or -json), every
+// event is written as a line of JSON instead, so that wrapping tools can show progress and collect
+// warnings.
+var diag = &reporter{}
+
+// Values of -log-format
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+type reporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder // nil for text output
+
+	// quiet hides warnings in text output, for -q
+	quiet bool
+
+	filesParsed  int
+	filesSkipped int
+	warnings     int
+}
+
+func newJSONReporter(w io.Writer) *reporter {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false) // build constraints are full of '&&'
+	return &reporter{enc: enc}
+}
+
+// event is a single line of JSON output
+type event struct {
+	Time time.Time `json:"time"`
+	// Event is one of "fileParsed", "fileSkipped", "warning", or "summary" for prepare,
+	// "phaseStart", "phaseEnd", "packageCompiled", "packageFailed", "verify", or "stats" for cook,
+	// and "done" for both
+	Event string `json:"event"`
+
+	Path    string `json:"path,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+
+	Phase    string  `json:"phase,omitempty"`
+	Package  string  `json:"package,omitempty"`
+	Status   string  `json:"status,omitempty"` // "ok" or "failed"
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"durationSeconds,omitempty"`
+
+	// Compiled and Total are the number of packages that one 'go build' compiled so far, and
+	// could compile in all, for -v
+	Compiled int `json:"compiled,omitempty"`
+	Total    int `json:"total,omitempty"`
+
+	Summary *prepareSummary `json:"summary,omitempty"`
+	Verify  *verifySummary  `json:"verify,omitempty"`
+	Stats   *cookStats      `json:"stats,omitempty"`
+}
+
+type prepareSummary struct {
+	FilesParsed  int `json:"filesParsed"`
+	FilesSkipped int `json:"filesSkipped"`
+	ImportGroups int `json:"importGroups"`
+	Packages     int `json:"packages"`
+	Warnings     int `json:"warnings"`
+}
+
+type verifySummary struct {
+	Packages    int      `json:"packages"`
+	CacheHits   int      `json:"cacheHits"`
+	CacheMisses []string `json:"cacheMisses,omitempty"`
+}
+
+// emit writes the event, if we're producing JSON output. The caller must hold r.mu.
+func (r *reporter) emit(e event) {
+	if r.enc == nil {
+		return
+	}
+	e.Time = time.Now().UTC()
+	_ = r.enc.Encode(&e) // nothing sensible to do if stdout is broken
+}
+
+func (r *reporter) fileParsed(path string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.filesParsed++
+	r.emit(event{Event: "fileParsed", Path: path})
+}
+
+func (r *reporter) fileSkipped(path string, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.filesSkipped++
+	r.emit(event{Event: "fileSkipped", Path: path, Reason: reason})
+}
+
+func (r *reporter) warn(msg string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.warnings++
+	if r.enc == nil {
+		if !r.quiet {
+			fmt.Fprintf(os.Stderr, "warning: %s\n", msg)
+		}
+		return
+	}
+	r.emit(event{Event: "warning", Message: msg})
+}
+
+// prepareDone reports the summary of a successful prepare
+func (r *reporter) prepareDone(groups []importGroup) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := prepareSummary{
+		FilesParsed:  r.filesParsed,
+		FilesSkipped: r.filesSkipped,
+		ImportGroups: len(groups),
+		Warnings:     r.warnings,
+	}
+	for _, g := range groups {
+		s.Packages += len(g.Packages)
+	}
+	r.emit(event{Event: "summary", Summary: &s})
+}
+
+// output returns where the output of go commands should go: stdout, unless that's where the
+// events go
+func (r *reporter) output() io.Writer {
+	if r.enc != nil {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// runPhase runs f as a phase of cook, like downloading or building the dependencies of one module
+// (whose directory is path), and reports when it starts and ends
+func (r *reporter) runPhase(phase, path string, f func() error) error {
+	r.mu.Lock()
+	r.emit(event{Event: "phaseStart", Phase: phase, Path: path})
+	r.mu.Unlock()
+
+	start := time.Now()
+	err := f()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e := event{Event: "phaseEnd", Phase: phase, Path: path, Status: "ok", Duration: time.Since(start).Seconds()}
+	if err != nil {
+		e.Status, e.Error = "failed", err.Error()
+	}
+	r.emit(e)
+	return err
+}
+
+// packageCompiled reports a package that was compiled during a cook with -v, as the nth of at
+// most total. Packages that were already in the build cache aren't compiled, so the count usually
+// stops short of the total. total is 0 if unknown.
+func (r *reporter) packageCompiled(pkg string, n, total int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.enc == nil {
+		if total == 0 {
+			fmt.Fprintf(os.Stderr, "[%d] %s\n", n, pkg)
+		} else {
+			fmt.Fprintf(os.Stderr, "[%d/%d] %s\n", n, total, pkg)
+		}
+		return
+	}
+	r.emit(event{Event: "packageCompiled", Package: pkg, Compiled: n, Total: total})
+}
+
+// packageFailed reports a package that couldn't be built during a cook with -keep-going. pkg is
+// empty for errors that aren't for any package in particular.
+func (r *reporter) packageFailed(pkg, err string) {
+	if r.enc == nil {
+		msg := strings.ReplaceAll(err, "\n", "\n\t")
+		if pkg == "" {
+			r.warn(fmt.Sprintf("could not build:\n\t%s", msg))
+		} else {
+			r.warn(fmt.Sprintf("could not build %s:\n\t%s", pkg, msg))
+		}
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.emit(event{Event: "packageFailed", Package: pkg, Error: err})
+}
+
+// verified reports the results of -verify-targets: which of deps, the dependency packages of the
+// targets, were cache misses
+func (r *reporter) verified(deps []string, misses []string) {
+	hits := len(deps) - len(misses)
+	if r.enc == nil {
+		percent := 100.0
+		if len(deps) != 0 {
+			percent = 100 * float64(hits) / float64(len(deps))
+		}
+		fmt.Printf("verify: %d of %d dependency packages were cache hits (%.0f%%)\n", hits, len(deps), percent)
+		for _, pkg := range misses {
+			fmt.Printf("verify: cache miss: %s\n", pkg)
+		}
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.emit(event{Event: "verify", Verify: &verifySummary{Packages: len(deps), CacheHits: hits, CacheMisses: misses}})
+}
+
+// cookDone reports the stats of a successful cook, for -stats
+func (r *reporter) cookDone(s cookStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.enc != nil {
+		r.emit(event{Event: "stats", Stats: &s})
+		return
+	}
+	fmt.Fprintf(os.Stderr, "cook: compiled %d packages in %.1fs of compile time, in %.1fs overall\n", s.PackagesCompiled, s.CompileSeconds, s.DurationSeconds)
+	if s.GoCacheAdded != nil && s.GoModCacheAdded != nil {
+		fmt.Fprintf(os.Stderr, "cook: added %s to GOCACHE and %s to GOMODCACHE\n", formatBytes(*s.GoCacheAdded), formatBytes(*s.GoModCacheAdded))
+	}
+	for _, p := range s.Slowest {
+		fmt.Fprintf(os.Stderr, "cook: slowest: %5.1fs %s\n", p.Seconds, p.Package)
+	}
+}
+
+// done reports the final status of prepare or cook, which took the given time. The error itself
+// is printed by main as well.
+func (r *reporter) done(err error, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e := event{Event: "done", Status: "ok", Duration: d.Seconds()}
+	if err != nil {
+		e.Status, e.Error = "failed", err.Error()
+	}
+	r.emit(e)
+}
+
+// warnf reports a warning
+func warnf(format string, args ...any) {
+	diag.warn(fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// recipeEnvVars are the settings of the go command that prepare records in the recipe, because
+// cache entries for one value are never used by builds with another
+var recipeEnvVars = []string{"GOOS", "GOARCH", "CGO_ENABLED"}
+
+// readGoEnv returns the values of the variables from 'go env', run as cmd
+func readGoEnv(cmd *exec.Cmd, vars []string) (map[string]string, error) {
+	cmd.Args = append(cmd.Args, append([]string{"env", "-json"}, vars...)...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("could not run 'go env': %w", err)
+	}
+	env := make(map[string]string)
+	if err := json.Unmarshal(out.Bytes(), &env); err != nil {
+		return nil, fmt.Errorf("could not parse 'go env' output: %w", err)
+	}
+	return env, nil
+}
+
+// prepareEnv returns the build environment the recipe is prepared for: the one in which prepare
+// runs, except for -goos and -goarch. With -platforms, GOOS and GOARCH are left out, since cook
+// picks them -- and so is the default for CGO_ENABLED, which depends on them.
+func prepareEnv(opts prepareOptions) (map[string]string, error) {
+	cmd := exec.Command("go")
+	cmd.Env = os.Environ()
+	if opts.goos != "" {
+		cmd.Env = append(cmd.Env, "GOOS="+opts.goos)
+	}
+	if opts.goarch != "" {
+		cmd.Env = append(cmd.Env, "GOARCH="+opts.goarch)
+	}
+	cmd.Stderr = os.Stderr
+	env, err := readGoEnv(cmd, recipeEnvVars)
+	if err != nil {
+		return nil, err
+	}
+	if opts.platforms != "" {
+		delete(env, "GOOS")
+		delete(env, "GOARCH")
+		if _, ok := os.LookupEnv("CGO_ENABLED"); !ok {
+			delete(env, "CGO_ENABLED")
+		}
+	}
+	return env, nil
+}
+
+// checkCookEnv compares the build environment of cook with the one the recipe was prepared for,
+// warning about every difference -- or with -strict-env, returning an error.
+//
+// Packages compiled for a different GOOS, GOARCH, or CGO_ENABLED end up in different cache entries,
+// so the final build wouldn't use them.
+func checkCookEnv(opts cookOptions, r *recipe) error {
+	if len(r.Env) == 0 {
+		return nil
+	}
+	var vars []string
+	for _, name := range recipeEnvVars {
+		if _, ok := r.Env[name]; ok {
+			vars = append(vars, name)
+		}
+	}
+	// The environment of cook's own go commands, which may have CGO_ENABLED=1 forced by cook
+	env, err := readGoEnv(opts.goCommand(), vars)
+	if err != nil {
+		return err
+	}
+
+	var mismatches []string
+	for _, name := range vars {
+		if env[name] == r.Env[name] {
+			continue
+		}
+		if name == "CGO_ENABLED" && opts.forceCgo() {
+			mismatches = append(mismatches, fmt.Sprintf("CGO_ENABLED=1 (set by cook for the cgo import groups, set CGO_ENABLED explicitly to override it) instead of CGO_ENABLED=%s", r.Env[name]))
+		} else {
+			mismatches = append(mismatches, fmt.Sprintf("%s=%s instead of %s=%s", name, env[name], name, r.Env[name]))
+		}
+	}
+	if len(mismatches) == 0 {
+		return nil
+	}
+	list := strings.Join(mismatches, ", ")
+	if opts.strictEnv {
+		return fmt.Errorf("error: Cooking with %s from the recipe, so the final build wouldn't use the cooked packages", list)
+	}
+	warnf("cooking with %s from the recipe, so the final build may not use the cooked packages", list)
+	return nil
+}
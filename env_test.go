@@ -0,0 +1,16 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckCookEnvForcedCgo(t *testing.T) {
+	unsetEnv(t, "CGO_ENABLED")
+	r := &recipe{Env: map[string]string{"CGO_ENABLED": "0"}}
+
+	err := checkCookEnv(cookOptions{strictEnv: true, cgo: true}, r)
+	if err == nil || !strings.Contains(err.Error(), "CGO_ENABLED=1 (set by cook for the cgo import groups") {
+		t.Errorf("checkCookEnv() with forced cgo = %v, want an error about cook setting CGO_ENABLED=1", err)
+	}
+}
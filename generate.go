@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"slices"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// knownGenerators maps the names of commonly used code generators to the packages providing them.
+// A //go:generate line that runs one of them by name only counts if go.mod requires the module.
+var knownGenerators = map[string][]string{
+	"controller-gen":     {"sigs.k8s.io/controller-tools/cmd/controller-gen"},
+	"easyjson":           {"github.com/mailru/easyjson/easyjson"},
+	"enumer":             {"github.com/dmarkham/enumer"},
+	"mockery":            {"github.com/vektra/mockery/v2"},
+	"mockgen":            {"go.uber.org/mock/mockgen", "github.com/golang/mock/mockgen"},
+	"oapi-codegen":       {"github.com/oapi-codegen/oapi-codegen/v2/cmd/oapi-codegen", "github.com/deepmap/oapi-codegen/cmd/oapi-codegen"},
+	"protoc-gen-go":      {"google.golang.org/protobuf/cmd/protoc-gen-go"},
+	"protoc-gen-go-grpc": {"google.golang.org/grpc/cmd/protoc-gen-go-grpc"},
+	"sqlc":               {"github.com/sqlc-dev/sqlc/cmd/sqlc"},
+	"stringer":           {"golang.org/x/tools/cmd/stringer"},
+	"wire":               {"github.com/google/wire/cmd/wire"},
+}
+
+// generatorCommands returns the generators run by the //go:generate directives in src: either the
+// package given to 'go run' (possibly with an @version), or the name of the command.
+//
+// Like 'go generate', this only looks at lines starting with exactly "//go:generate ".
+func generatorCommands(src []byte) []string {
+	var cmds []string
+	scanner := bufio.NewScanner(bytes.NewReader(src))
+	for scanner.Scan() {
+		rest, ok := strings.CutPrefix(scanner.Text(), "//go:generate ")
+		if !ok {
+			continue
+		}
+		words := strings.Fields(rest)
+		if len(words) == 0 {
+			continue
+		}
+		if words[0] != "go" {
+			cmds = append(cmds, words[0])
+			continue
+		}
+		if len(words) < 3 || words[1] != "run" {
+			// 'go tool' only runs tools from go.mod, which are already recorded
+			continue
+		}
+		for _, w := range words[2:] {
+			if strings.HasPrefix(w, "-") {
+				continue // build flag
+			}
+			// 'go run file.go' and 'go run ./pkg' run local code
+			if !strings.HasSuffix(w, ".go") && !strings.HasPrefix(w, ".") {
+				cmds = append(cmds, w)
+			}
+			break
+		}
+	}
+	return cmds
+}
+
+// resolveGenerators turns the generator commands found in the module into tool packages, dropping
+// the ones that are part of the module itself or that can't be resolved.
+func resolveGenerators(b *importsBuilder, mf *modfile.File, cmds []string) []string {
+	isRequired := func(pkg string) bool {
+		return slices.ContainsFunc(mf.Require, func(req *modfile.Require) bool {
+			return pkg == req.Mod.Path || strings.HasPrefix(pkg, req.Mod.Path+"/")
+		})
+	}
+
+	var tools []string
+	for _, cmd := range cmds {
+		pkg, _, hasVersion := strings.Cut(cmd, "@")
+		switch {
+		case hasVersion:
+			// Built outside of the module, like 'go install pkg@version'
+			tools = append(tools, cmd)
+		case strings.Contains(pkg, "/"):
+			if !b.isLocal(pkg) {
+				tools = append(tools, pkg)
+			}
+		default:
+			for _, known := range knownGenerators[pkg] {
+				if isRequired(known) {
+					tools = append(tools, known)
+					break
+				}
+			}
+		}
+	}
+	return tools
+}
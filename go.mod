@@ -1,5 +1,9 @@
 module github.com/neondatabase/go-chef
 
-go 1.21.8
+go 1.22.0
 
-require golang.org/x/mod v0.18.0
+require (
+	github.com/klauspost/compress v1.18.0
+	github.com/santhosh-tekuri/jsonschema/v5 v5.3.1
+	golang.org/x/mod v0.22.0
+)
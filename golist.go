@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+)
+
+const (
+	// prepareModeAST finds imports by parsing source files, which works without network access or
+	// even a Go toolchain
+	prepareModeAST = "ast"
+	// prepareModeGoList finds imports with 'go list', which applies all of the go command's rules
+	// for the chosen build configuration
+	prepareModeGoList = "golist"
+)
+
+const (
+	// granularityPackage records every imported package, so cook builds exactly those
+	granularityPackage = "package"
+	// granularityModule only records the modules providing the imported packages, and cook builds
+	// all of their packages. Importing another package from the same module then doesn't change
+	// the recipe.
+	granularityModule = "module"
+)
+
+// listedPackage is the subset of 'go list -json' output that listImports needs
+type listedPackage struct {
+	ImportPath   string
+	Imports      []string
+	TestImports  []string
+	XTestImports []string
+	CgoFiles     []string
+}
+
+// listImports adds the imports of all packages in the module to builder (and their test imports to
+// testBuilder) by running 'go list ./...' in opts.moduleDir.
+//
+// Unlike scanning source files, this only sees the files selected by the build configuration
+// (opts.goos, opts.goarch, and opts.tags), so everything is recorded without build constraints.
+// It also needs the module's dependencies, so it may download them.
+func listImports(opts prepareOptions, builder, testBuilder *importsBuilder) error {
+	if opts.moduleDir == "" {
+		return errors.New("-mode=golist needs the module to be on disk")
+	}
+
+	args := []string{"list", "-json=ImportPath,Imports,TestImports,XTestImports,CgoFiles"}
+	if opts.tags != "" {
+		args = append(args, "-tags", opts.tags)
+	}
+	cmd := exec.Command("go", append(args, "./...")...)
+	cmd.Dir = opts.moduleDir
+	cmd.Env = os.Environ()
+	if opts.goos != "" {
+		cmd.Env = append(cmd.Env, "GOOS="+opts.goos)
+	}
+	if opts.goarch != "" {
+		cmd.Env = append(cmd.Env, "GOARCH="+opts.goarch)
+	}
+	if opts.gowork == "off" {
+		cmd.Env = append(cmd.Env, "GOWORK=off")
+	}
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("could not run 'go list': %w", err)
+	}
+
+	// The output is a stream of JSON objects, one per package
+	dec := json.NewDecoder(&stdout)
+	for {
+		var pkg listedPackage
+		if err := dec.Decode(&pkg); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("could not parse 'go list' output: %w", err)
+		}
+
+		usesCgo := len(pkg.CgoFiles) != 0
+		builder.addImports("", pkg.Imports, usesCgo)
+		if opts.includeTests {
+			testBuilder.addImports("", slices.Concat(pkg.TestImports, pkg.XTestImports), false)
+		}
+		diag.fileParsed(pkg.ImportPath)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// minimizeGoMod rewrites go.mod to the smallest equivalent one for the recorded packages, so that
+// cosmetic or unrelated edits to go.mod don't change the recipe.
+//
+// Only the requirements on modules reachable in the module graph from the modules providing the
+// recorded packages (and tools) are kept, at the same versions -- so minimal version selection
+// still picks the same versions for everything that's built. Requirements on local modules are
+// always kept. Comments are dropped, and blocks are sorted.
+func minimizeGoMod(opts prepareOptions, mf *modfile.File, localModules []string, groups []importGroup, tools []string) ([]byte, error) {
+	if opts.moduleDir == "" {
+		return nil, errors.New("-minimize-gomod needs the module to be on disk")
+	}
+
+	edges, err := loadModuleGraph(opts)
+	if err != nil {
+		return nil, fmt.Errorf("could not load module graph for -minimize-gomod: %w", err)
+	}
+	roots := graphRoots(mf, groups, tools)
+	for _, req := range mf.Require {
+		if slices.Contains(localModules, req.Mod.Path) {
+			roots = append(roots, req.Mod.String())
+		}
+	}
+	return minimalGoMod(mf, edges, roots)
+}
+
+// minimalGoMod returns go.mod with only the requirements on modules reachable in the module graph
+// from the roots, formatted without comments
+func minimalGoMod(mf *modfile.File, edges map[string][]string, roots []string) ([]byte, error) {
+	needed := make(map[string]bool)
+	for node := range reachableModules(edges, roots) {
+		path, _, _ := strings.Cut(node, "@")
+		needed[path] = true
+	}
+
+	min := new(modfile.File)
+	if err := min.AddModuleStmt(mf.Module.Mod.Path); err != nil {
+		return nil, err
+	}
+	if mf.Go != nil {
+		if err := min.AddGoStmt(mf.Go.Version); err != nil {
+			return nil, err
+		}
+	}
+	if mf.Toolchain != nil {
+		if err := min.AddToolchainStmt(mf.Toolchain.Name); err != nil {
+			return nil, err
+		}
+	}
+	for _, g := range mf.Godebug {
+		if err := min.AddGodebug(g.Key, g.Value); err != nil {
+			return nil, err
+		}
+	}
+	for _, req := range mf.Require {
+		if needed[req.Mod.Path] {
+			min.AddNewRequire(req.Mod.Path, req.Mod.Version, req.Indirect)
+		}
+	}
+	for _, rep := range mf.Replace {
+		if err := min.AddReplace(rep.Old.Path, rep.Old.Version, rep.New.Path, rep.New.Version); err != nil {
+			return nil, err
+		}
+	}
+	for _, ex := range mf.Exclude {
+		if err := min.AddExclude(ex.Mod.Path, ex.Mod.Version); err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range mf.Tool {
+		if err := min.AddTool(t.Path); err != nil {
+			return nil, err
+		}
+	}
+	min.SortBlocks()
+	min.Cleanup()
+	contents, err := min.Format()
+	if err != nil {
+		return nil, fmt.Errorf("could not format go.mod: %w", err)
+	}
+	return contents, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/mod/modfile"
+)
+
+func TestMinimalGoMod(t *testing.T) {
+	tests := []struct {
+		name  string
+		gomod string
+		graph string
+		roots []string
+		want  string
+	}{
+		{
+			name: "unrelated requirements and comments",
+			gomod: `// The main module
+module example.com/m
+
+go 1.22.0
+
+require (
+	example.com/b v1.0.0 // for the tests
+	example.com/a v1.0.0
+)
+
+require example.com/c v1.0.0 // indirect
+`,
+			graph: `example.com/m example.com/a@v1.0.0
+example.com/m example.com/b@v1.0.0
+example.com/m example.com/c@v1.0.0
+example.com/a@v1.0.0 example.com/c@v1.0.0
+`,
+			roots: []string{"example.com/a@v1.0.0"},
+			want: `module example.com/m
+
+go 1.22.0
+
+require (
+	example.com/a v1.0.0
+	example.com/c v1.0.0 // indirect
+)
+`,
+		},
+		{
+			name:  "selected version of transitive dependency",
+			gomod: "module example.com/tt\n\ngo 1.22.0\n\nrequire golang.org/x/tools v0.22.0\n\nrequire (\n\tgolang.org/x/mod v0.22.0 // indirect\n\tgolang.org/x/sync v0.7.0 // indirect\n)\n",
+			graph: transitiveGraph,
+			roots: []string{"golang.org/x/tools@v0.22.0"},
+			want:  "module example.com/tt\n\ngo 1.22.0\n\nrequire (\n\tgolang.org/x/mod v0.22.0 // indirect\n\tgolang.org/x/sync v0.7.0 // indirect\n\tgolang.org/x/tools v0.22.0\n)\n",
+		},
+		{
+			name: "directives besides requirements",
+			gomod: `module example.com/m
+
+go 1.23
+
+toolchain go1.23.4
+
+godebug panicnil=1
+
+require (
+	example.com/a v1.0.0
+	example.com/b v1.0.0
+)
+
+replace example.com/a => ../a
+
+exclude example.com/b v0.9.0
+
+tool example.com/b/cmd/gen
+`,
+			graph: `example.com/m example.com/a@v1.0.0
+example.com/m example.com/b@v1.0.0
+`,
+			roots: []string{"example.com/b@v1.0.0"},
+			want: `module example.com/m
+
+go 1.23
+
+toolchain go1.23.4
+
+godebug panicnil=1
+
+require example.com/b v1.0.0
+
+replace example.com/a => ../a
+
+exclude example.com/b v0.9.0
+
+tool example.com/b/cmd/gen
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mf, err := modfile.Parse("go.mod", []byte(tt.gomod), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := minimalGoMod(mf, parseGraph(tt.graph), tt.roots)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("minimalGoMod() =\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestMinimizeGoModTransitive runs 'go mod graph' on testdata/transitive, like
+// TestTrimGoSumTransitive
+func TestMinimizeGoModTransitive(t *testing.T) {
+	dir := filepath.Join("testdata", "transitive")
+	mf, _ := readModuleFiles(t, dir)
+	opts := prepareOptions{moduleDir: dir}
+	if _, err := loadModuleGraph(opts); err != nil {
+		t.Skipf("module graph isn't available: %v", err)
+	}
+
+	groups := []importGroup{{Packages: []string{"golang.org/x/tools/go/packages"}}}
+	got, err := minimizeGoMod(opts, mf, nil, groups, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Everything in go.mod is needed, so minimizing only changes the formatting
+	min, err := modfile.Parse("go.mod", got, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(min.Require) != len(mf.Require) {
+		t.Errorf("minimized go.mod has %d requirements, want %d:\n%s", len(min.Require), len(mf.Require), got)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/semver"
+)
+
+// trimGoSum filters the go.sum contents down to the entries that cook can need for the recorded
+// packages, so that unrelated changes to go.sum don't change the recipe. See filterGoSum for which
+// entries are kept.
+//
+// The module graph comes from 'go mod graph' in opts.moduleDir, which may need to download go.mod
+// files of dependencies.
+func trimGoSum(opts prepareOptions, mf *modfile.File, groups []importGroup, tools []string, sumContents []byte) ([]byte, error) {
+	if opts.moduleDir == "" {
+		return nil, errors.New("-trim-gosum needs the module to be on disk")
+	}
+
+	edges, err := loadModuleGraph(opts)
+	if err != nil {
+		return nil, fmt.Errorf("could not load module graph for -trim-gosum: %w", err)
+	}
+	return filterGoSum(mf, edges, graphRoots(mf, groups, tools), sumContents), nil
+}
+
+// filterGoSum returns the entries of the go.sum contents that cook can need to build the packages
+// of the root modules, given the module graph:
+//
+//   - module zip hashes, only for the selected versions of modules reachable in the module graph
+//     from the roots (see reachableModules), since any of them can provide a package that a
+//     package of the roots imports
+//   - go.mod hashes, for every module in the module graph, since they're all needed to compute
+//     the build list
+func filterGoSum(mf *modfile.File, edges map[string][]string, roots []string, sumContents []byte) []byte {
+	inGraph := make(map[string]bool)
+	for from, tos := range edges {
+		inGraph[from] = true
+		for _, to := range tos {
+			inGraph[to] = true
+		}
+	}
+	reachable := reachableModules(edges, roots)
+
+	// go.sum lists replacement modules, not the ones in the graph
+	replaced := func(node string) string {
+		path, version, _ := strings.Cut(node, "@")
+		for _, r := range mf.Replace {
+			if r.Old.Path == path && (r.Old.Version == "" || r.Old.Version == version) && r.New.Version != "" {
+				return r.New.Path + "@" + r.New.Version
+			}
+		}
+		return node
+	}
+	keepZip := make(map[string]bool)
+	keepMod := make(map[string]bool)
+	for node := range inGraph {
+		keepMod[replaced(node)] = true
+	}
+	for node := range reachable {
+		keepZip[replaced(node)] = true
+	}
+
+	var sum strings.Builder
+	for _, line := range strings.SplitAfter(string(sumContents), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		if version, ok := strings.CutSuffix(fields[1], "/go.mod"); ok {
+			if keepMod[fields[0]+"@"+version] {
+				sum.WriteString(line)
+			}
+		} else if keepZip[fields[0]+"@"+fields[1]] {
+			sum.WriteString(line)
+		}
+	}
+	return []byte(sum.String())
+}
+
+// loadModuleGraph returns the module graph from 'go mod graph' in opts.moduleDir, as a map from
+// each "path@version" (or just "path" for the main module) to the modules it requires.
+func loadModuleGraph(opts prepareOptions) (map[string][]string, error) {
+	cmd := exec.Command("go", "mod", "graph")
+	cmd.Dir = opts.moduleDir
+	cmd.Env = os.Environ()
+	if opts.gowork == "off" {
+		cmd.Env = append(cmd.Env, "GOWORK=off")
+	}
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("could not run 'go mod graph': %w", err)
+	}
+
+	// Each line is an edge like "example.com/a@v1.0.0 example.com/b@v1.2.0"
+	edges := make(map[string][]string)
+	for _, line := range strings.Split(out.String(), "\n") {
+		from, to, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		edges[from] = append(edges[from], to)
+	}
+	return edges, nil
+}
+
+// graphRoots returns the "path@version" of the required modules that provide the packages in the
+// import groups, or the tools that are built within the module.
+func graphRoots(mf *modfile.File, groups []importGroup, tools []string) []string {
+	var pkgs []string
+	for _, g := range groups {
+		pkgs = append(pkgs, g.Packages...)
+	}
+	for _, tool := range tools {
+		// 'pkg@version' tools are built outside of the module
+		if !strings.Contains(tool, "@") {
+			pkgs = append(pkgs, tool)
+		}
+	}
+	var roots []string
+	for _, mod := range providingModules(mf, []importGroup{{Packages: pkgs}}) {
+		for _, req := range mf.Require {
+			if req.Mod.Path == mod {
+				roots = append(roots, req.Mod.String())
+			}
+		}
+	}
+	return roots
+}
+
+// reachableModules returns the set of nodes in the module graph reachable from the roots,
+// including the roots themselves, at the versions that minimal version selection picks for them.
+//
+// Only the requirements of those selected versions are followed. A module that's required at an
+// older version, like golang.org/x/mod@v0.18.0 by golang.org/x/tools, is built at its selected
+// version, whose requirements are the ones that matter -- and it's the selected version whose zip
+// cook downloads.
+func reachableModules(edges map[string][]string, roots []string) map[string]bool {
+	selected := selectedVersions(edges)
+	atSelected := func(node string) string {
+		path, _, ok := strings.Cut(node, "@")
+		if version := selected[path]; ok && version != "" {
+			return path + "@" + version
+		}
+		return node
+	}
+
+	reachable := make(map[string]bool)
+	var queue []string
+	for _, root := range roots {
+		queue = append(queue, atSelected(root))
+	}
+	for len(queue) != 0 {
+		node := queue[0]
+		queue = queue[1:]
+		if reachable[node] {
+			continue
+		}
+		reachable[node] = true
+		for _, to := range edges[node] {
+			queue = append(queue, atSelected(to))
+		}
+	}
+	return reachable
+}
+
+// selectedVersions returns the version of each module in the module graph that minimal version
+// selection picks, which is the highest one in the graph
+func selectedVersions(edges map[string][]string) map[string]string {
+	selected := make(map[string]string)
+	add := func(node string) {
+		path, version, ok := strings.Cut(node, "@")
+		if ok && (selected[path] == "" || semver.Compare(version, selected[path]) > 0) {
+			selected[path] = version
+		}
+	}
+	for from, tos := range edges {
+		add(from)
+		for _, to := range tos {
+			add(to)
+		}
+	}
+	return selected
+}
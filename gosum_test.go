@@ -0,0 +1,185 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/mod/modfile"
+)
+
+// transitiveGraph is the 'go mod graph' output of testdata/transitive, which imports
+// golang.org/x/tools/go/packages. That imports golang.org/x/mod/semver, from golang.org/x/mod
+// v0.22.0 as required by the main module, even though golang.org/x/tools only requires v0.18.0.
+const transitiveGraph = `example.com/tt go@1.22.0
+example.com/tt golang.org/x/mod@v0.22.0
+example.com/tt golang.org/x/sync@v0.7.0
+example.com/tt golang.org/x/tools@v0.22.0
+go@1.22.0 toolchain@go1.22.0
+golang.org/x/mod@v0.22.0 golang.org/x/tools@v0.13.0
+golang.org/x/mod@v0.22.0 go@1.22.0
+golang.org/x/tools@v0.22.0 github.com/google/go-cmp@v0.6.0
+golang.org/x/tools@v0.22.0 github.com/yuin/goldmark@v1.4.13
+golang.org/x/tools@v0.22.0 golang.org/x/mod@v0.18.0
+golang.org/x/tools@v0.22.0 golang.org/x/net@v0.26.0
+golang.org/x/tools@v0.22.0 golang.org/x/sync@v0.7.0
+golang.org/x/tools@v0.22.0 golang.org/x/telemetry@v0.0.0-20240521205824-bda55230c457
+golang.org/x/tools@v0.22.0 golang.org/x/sys@v0.21.0
+`
+
+func parseGraph(graph string) map[string][]string {
+	edges := make(map[string][]string)
+	for _, line := range strings.Split(strings.TrimSpace(graph), "\n") {
+		from, to, _ := strings.Cut(line, " ")
+		edges[from] = append(edges[from], to)
+	}
+	return edges
+}
+
+func TestFilterGoSum(t *testing.T) {
+	tests := []struct {
+		name  string
+		gomod string
+		graph string
+		roots []string
+		sum   string
+		want  string
+	}{
+		{
+			name:  "selected version of transitive dependency",
+			gomod: "module example.com/tt\n",
+			graph: transitiveGraph,
+			roots: []string{"golang.org/x/tools@v0.22.0"},
+			sum: `golang.org/x/mod v0.18.0 h1:old=
+golang.org/x/mod v0.18.0/go.mod h1:oldmod=
+golang.org/x/mod v0.22.0 h1:mod=
+golang.org/x/mod v0.22.0/go.mod h1:modmod=
+golang.org/x/sync v0.7.0 h1:sync=
+golang.org/x/sync v0.7.0/go.mod h1:syncmod=
+golang.org/x/tools v0.22.0 h1:tools=
+golang.org/x/tools v0.22.0/go.mod h1:toolsmod=
+`,
+			want: `golang.org/x/mod v0.18.0/go.mod h1:oldmod=
+golang.org/x/mod v0.22.0 h1:mod=
+golang.org/x/mod v0.22.0/go.mod h1:modmod=
+golang.org/x/sync v0.7.0 h1:sync=
+golang.org/x/sync v0.7.0/go.mod h1:syncmod=
+golang.org/x/tools v0.22.0 h1:tools=
+golang.org/x/tools v0.22.0/go.mod h1:toolsmod=
+`,
+		},
+		{
+			name:  "unrelated module",
+			gomod: "module example.com/m\n",
+			graph: `example.com/m example.com/a@v1.0.0
+example.com/m example.com/b@v1.0.0
+example.com/a@v1.0.0 example.com/c@v1.0.0
+`,
+			roots: []string{"example.com/a@v1.0.0"},
+			sum: `example.com/a v1.0.0 h1:a=
+example.com/a v1.0.0/go.mod h1:amod=
+example.com/b v1.0.0 h1:b=
+example.com/b v1.0.0/go.mod h1:bmod=
+example.com/c v1.0.0 h1:c=
+example.com/c v1.0.0/go.mod h1:cmod=
+example.com/d v1.0.0/go.mod h1:dmod=
+`,
+			want: `example.com/a v1.0.0 h1:a=
+example.com/a v1.0.0/go.mod h1:amod=
+example.com/b v1.0.0/go.mod h1:bmod=
+example.com/c v1.0.0 h1:c=
+example.com/c v1.0.0/go.mod h1:cmod=
+`,
+		},
+		{
+			name:  "requirements of the selected version",
+			gomod: "module example.com/m\n",
+			graph: `example.com/m example.com/a@v1.0.0
+example.com/m example.com/b@v1.1.0
+example.com/a@v1.0.0 example.com/b@v1.0.0
+example.com/b@v1.0.0 example.com/old@v1.0.0
+example.com/b@v1.1.0 example.com/new@v1.0.0
+`,
+			roots: []string{"example.com/a@v1.0.0"},
+			sum: `example.com/a v1.0.0 h1:a=
+example.com/b v1.0.0 h1:b0=
+example.com/b v1.1.0 h1:b1=
+example.com/new v1.0.0 h1:new=
+example.com/old v1.0.0 h1:old=
+`,
+			want: `example.com/a v1.0.0 h1:a=
+example.com/b v1.1.0 h1:b1=
+example.com/new v1.0.0 h1:new=
+`,
+		},
+		{
+			name: "replaced module",
+			gomod: `module example.com/m
+
+replace example.com/a => example.com/fork v1.2.0
+`,
+			graph: `example.com/m example.com/a@v1.0.0
+`,
+			roots: []string{"example.com/a@v1.0.0"},
+			sum: `example.com/a v1.0.0 h1:a=
+example.com/fork v1.2.0 h1:fork=
+example.com/fork v1.2.0/go.mod h1:forkmod=
+`,
+			want: `example.com/fork v1.2.0 h1:fork=
+example.com/fork v1.2.0/go.mod h1:forkmod=
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mf, err := modfile.Parse("go.mod", []byte(tt.gomod), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := string(filterGoSum(mf, parseGraph(tt.graph), tt.roots, []byte(tt.sum)))
+			if got != tt.want {
+				t.Errorf("filterGoSum() =\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestTrimGoSumTransitive runs 'go mod graph' on testdata/transitive, which needs the go.mod files
+// of its dependencies from the module cache or GOPROXY
+func TestTrimGoSumTransitive(t *testing.T) {
+	dir := filepath.Join("testdata", "transitive")
+	mf, sum := readModuleFiles(t, dir)
+	opts := prepareOptions{moduleDir: dir}
+	if _, err := loadModuleGraph(opts); err != nil {
+		t.Skipf("module graph isn't available: %v", err)
+	}
+
+	groups := []importGroup{{Packages: []string{"golang.org/x/tools/go/packages"}}}
+	trimmed, err := trimGoSum(opts, mf, groups, nil, sum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"golang.org/x/mod v0.22.0 h1:", "golang.org/x/tools v0.22.0 h1:"} {
+		if !strings.Contains(string(trimmed), want) {
+			t.Errorf("trimmed go.sum is missing %q:\n%s", want, trimmed)
+		}
+	}
+}
+
+func readModuleFiles(t *testing.T, dir string) (*modfile.File, []byte) {
+	t.Helper()
+	gomod, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mf, err := modfile.Parse("go.mod", gomod, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, err := os.ReadFile(filepath.Join(dir, "go.sum"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mf, sum
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// checkGoVersion returns an error if the local go command is older than the newest go directive in
+// the recipe, and GOTOOLCHAIN doesn't allow it to switch to a newer toolchain by itself.
+//
+// Without this, the build fails partway through with errors like "go.mod requires go >= 1.23",
+// or -- for older toolchains -- with confusing compile errors.
+func checkGoVersion(opts cookOptions, r *recipe) error {
+	required, source := r.requiredGoVersion()
+	if required == "" {
+		return nil
+	}
+
+	cmd := opts.goCommand("env", "-json", "GOVERSION", "GOTOOLCHAIN")
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("could not get Go version from 'go env': %w", err)
+	}
+	var env struct {
+		GOVERSION   string
+		GOTOOLCHAIN string
+	}
+	if err := json.Unmarshal(out.Bytes(), &env); err != nil {
+		return fmt.Errorf("could not parse 'go env' output: %w", err)
+	}
+
+	// GOVERSION may be followed by experiments, like "go1.23.1 X:rangefunc"
+	local, _, _ := strings.Cut(strings.TrimPrefix(env.GOVERSION, "go"), " ")
+	if compareGoVersions(local, required) >= 0 {
+		return nil
+	}
+
+	// With GOTOOLCHAIN=auto (the default), the go command downloads the required toolchain itself.
+	// With path, it never downloads, but runs a toolchain like go1.23.1 from PATH if there's a new
+	// enough one.
+	switch {
+	case env.GOTOOLCHAIN == "auto", strings.HasSuffix(env.GOTOOLCHAIN, "+auto"):
+		return nil
+	case env.GOTOOLCHAIN == "path", strings.HasSuffix(env.GOTOOLCHAIN, "+path"):
+		if pathHasToolchain(os.Getenv("PATH"), required) {
+			return nil
+		}
+		return fmt.Errorf("error: The recipe requires Go %s (from the go directive in %s), but the local toolchain is Go %s and GOTOOLCHAIN=%s only switches to toolchains in PATH, which has none that new. Use a newer Go image, or set GOTOOLCHAIN=auto", required, source, local, env.GOTOOLCHAIN)
+	}
+	return fmt.Errorf("error: The recipe requires Go %s (from the go directive in %s), but the local toolchain is Go %s and GOTOOLCHAIN=%s doesn't allow switching. Use a newer Go image, or set GOTOOLCHAIN=auto", required, source, local, env.GOTOOLCHAIN)
+}
+
+// pathHasToolchain returns whether any directory in path has a toolchain binary like go1.23.1 that
+// is at least the required Go version, which is where the go command looks with GOTOOLCHAIN=path.
+func pathHasToolchain(path, required string) bool {
+	for _, dir := range filepath.SplitList(path) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			name := strings.TrimSuffix(e.Name(), ".exe")
+			v, ok := strings.CutPrefix(name, "go1.")
+			if !ok || e.IsDir() {
+				continue
+			}
+			if compareGoVersions("1."+v, required) >= 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// requiredGoVersion returns the newest go directive in the recipe, including all of its modules,
+// and which file it's from
+func (r *recipe) requiredGoVersion() (version, source string) {
+	consider := func(v, src string) {
+		if v != "" && (version == "" || compareGoVersions(v, version) > 0) {
+			version, source = v, src
+		}
+	}
+
+	if r.GoWork != "" {
+		consider(r.GoVersion, "go.work")
+	} else {
+		consider(r.GoVersion, "go.mod")
+	}
+	for _, m := range append(slices.Clip(r.Modules), r.LocalReplaces...) {
+		v, src := m.requiredGoVersion()
+		consider(v, path.Join(m.Dir, src))
+	}
+	return version, source
+}
+
+// compareGoVersions compares two Go versions like "1.21", "1.21.3" or "1.22rc1", returning -1, 0,
+// or +1 like strings.Compare. As in the go command, "1.21" is older than "1.21rc1", which is older
+// than "1.21.0".
+func compareGoVersions(x, y string) int {
+	px, py := parseGoVersion(x), parseGoVersion(y)
+	for i := range px {
+		if px[i] != py[i] {
+			if px[i] < py[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// parseGoVersion splits a Go version into major, minor, a rank for the kind of release (language
+// version, beta, rc, or actual release), the beta/rc number, and the patch version. Unparseable
+// parts are treated as zero.
+func parseGoVersion(v string) [5]int {
+	var parsed [5]int
+	major, rest, _ := strings.Cut(v, ".")
+	parsed[0], _ = strconv.Atoi(major)
+
+	minor := rest
+	i := strings.IndexFunc(rest, func(c rune) bool { return c < '0' || c > '9' })
+	if i >= 0 {
+		minor, rest = rest[:i], rest[i:]
+	} else {
+		rest = ""
+	}
+	parsed[1], _ = strconv.Atoi(minor)
+
+	switch {
+	case rest == "":
+		parsed[2] = 0 // language version, like "1.21"
+	case strings.HasPrefix(rest, "beta"):
+		parsed[2] = 1
+		parsed[3], _ = strconv.Atoi(rest[len("beta"):])
+	case strings.HasPrefix(rest, "rc"):
+		parsed[2] = 2
+		parsed[3], _ = strconv.Atoi(rest[len("rc"):])
+	case strings.HasPrefix(rest, "."):
+		parsed[2] = 3
+		parsed[4], _ = strconv.Atoi(rest[1:])
+	}
+	return parsed
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPathHasToolchain(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"go", "go1.22.4", "gofmt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "go1.30.0"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "missing") + string(filepath.ListSeparator) + dir
+
+	tests := []struct {
+		required string
+		want     bool
+	}{
+		{"1.21", true},
+		{"1.22", true},
+		{"1.22.4", true},
+		{"1.22.5", false},
+		{"1.23", false},
+		// A directory named like a toolchain isn't one
+		{"1.30", false},
+	}
+	for _, tt := range tests {
+		if got := pathHasToolchain(path, tt.required); got != tt.want {
+			t.Errorf("pathHasToolchain(%q) = %v, want %v", tt.required, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// cookGroups returns the import groups of the module to build in parallel with -j, each as the main
+// package of its own directory (see cookGroupDir), or nil if the generated files are all built
+// together by a single 'go build' in the module's directory. That's the case with -j 1 or
+// -per-package, and when there aren't at least two groups to build for the target.
+//
+// Like with -per-package, the packages aren't behind the build constraints of their groups then,
+// so the groups that wouldn't be built for the target are skipped here instead.
+func cookGroups(opts cookOptions, m moduleRecipe) []importGroup {
+	if opts.perPackage || opts.jobs < 2 {
+		return nil
+	}
+	target := opts.cookTarget()
+	var groups []importGroup
+	for _, g := range m.cookImportGroups() {
+		if len(g.Packages) != 0 && target.matches(g.BuildConstraints) {
+			groups = append(groups, g)
+		}
+	}
+	if len(groups) < 2 {
+		return nil
+	}
+	// The group without build constraints goes first, see goBuildGroups
+	if i := slices.IndexFunc(groups, func(g importGroup) bool { return g.BuildConstraints == "" }); i > 0 {
+		groups[0], groups[i] = groups[i], groups[0]
+	}
+	return groups
+}
+
+// cookGroupDir returns the name of the directory, inside the module's, that the import group is
+// built in by itself: the name of its generated file (see cookFileName), without the extension.
+func cookGroupDir(g importGroup) string {
+	return strings.TrimSuffix(cookFileName(g.BuildConstraints), ".go")
+}
+
+// cookGroupContent returns the generated main package for an import group that's built by itself,
+// which isn't behind the group's build constraints
+func cookGroupContent(g importGroup) []byte {
+	return cookFileContent(importGroup{Packages: g.Packages})
+}
+
+// goBuildGroups builds the main packages of the import groups, in the groupDirs inside dir, like
+// goBuild. The first one is built by itself, and then the others up to -j at a time. The failures
+// of all groups are collected, and so are their errors.
+//
+// Concurrent builds don't wait for each other's results, so they'd all compile the packages every
+// group depends on, like the runtime. Building the first group (the one without build constraints,
+// if there is one) on its own puts most of those in the build cache for the others.
+func goBuildGroups(opts cookOptions, dir string, flags []string, groupDirs []string) ([]buildFailure, error) {
+	groupFailures := make([][]buildFailure, len(groupDirs))
+	build := func(i int) error {
+		var err error
+		groupFailures[i], err = goBuild(opts, filepath.Join(dir, groupDirs[i]), flags, []string{"."})
+		return err
+	}
+	err := build(0)
+	if err == nil {
+		err = runParallel(opts.jobs, len(groupDirs)-1, func(i int) error { return build(i + 1) })
+	}
+
+	var failures []buildFailure
+	for _, f := range groupFailures {
+		failures = append(failures, f...)
+	}
+	return failures, err
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCookGroups(t *testing.T) {
+	t.Setenv("CGO_ENABLED", "0")
+	m := moduleRecipe{Dir: ".", recipe: recipe{
+		ImportGroups: []importGroup{
+			{BuildConstraints: "linux", Packages: []string{"example.com/l"}},
+			{BuildConstraints: "windows", Packages: []string{"example.com/w"}},
+			{Packages: []string{"example.com/a"}},
+			{BuildConstraints: "cgo", Packages: []string{"example.com/c"}, Cgo: true},
+		},
+		TestImportGroups: []importGroup{{BuildConstraints: "linux && amd64", Packages: []string{"example.com/t"}}},
+	}}
+	linux := cookOptions{goos: "linux", goarch: "amd64", jobs: 4}
+
+	tests := []struct {
+		name string
+		opts cookOptions
+		m    moduleRecipe
+		want []string
+	}{
+		{"unconstrained group first", linux, m, []string{"chef_main", cookGroupDir(importGroup{BuildConstraints: "linux"}), cookGroupDir(importGroup{BuildConstraints: "linux && amd64"})}},
+		{"other target", cookOptions{goos: "windows", goarch: "arm64", jobs: 4}, m, []string{"chef_main", cookGroupDir(importGroup{BuildConstraints: "windows"})}},
+		{"single group for the target", cookOptions{goos: "darwin", goarch: "arm64", jobs: 4}, m, nil},
+		{"-j 1", cookOptions{goos: "linux", goarch: "amd64", jobs: 1}, m, nil},
+		{"-per-package", cookOptions{goos: "linux", goarch: "amd64", jobs: 4, perPackage: true}, m, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, g := range cookGroups(tt.opts, tt.m) {
+				got = append(got, cookGroupDir(g))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("cookGroups() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// buildFailure is a package that couldn't be built during a cook with -keep-going
+type buildFailure struct {
+	pkg string
+	err string
+}
+
+// goBuild runs 'go build' with the flags for pkgs in dir, discarding the results.
+//
+// With -keep-going, a failed build isn't an error: the packages that couldn't be built are returned
+// instead, and everything else is built. 'go build' already compiles every package that doesn't
+// depend on a failed one, but a package that can't even be loaded (like an import that no module
+// provides) stops it before compiling anything.
+//
+// With -v, every package that's compiled is reported as progress, see buildProgress. With -stats or
+// -stats-file, how long they took is collected for the stats of the cook, see withActionGraph.
+func goBuild(opts cookOptions, dir string, flags []string, pkgs []string) ([]buildFailure, error) {
+	buildFlags := slices.Clip(flags)
+	if opts.verbose {
+		buildFlags = append(buildFlags, "-v")
+	}
+	buildFlags, collectStats, err := opts.withActionGraph(buildFlags)
+	if err != nil {
+		return nil, err
+	}
+	defer collectStats()
+	cmd := opts.goCommand(goBuildArgs(buildFlags, pkgs)...)
+	cmd.Dir = dir
+	if opts.verbose {
+		cmd.Stderr = newBuildProgress(opts, dir, flags, pkgs, cmd.Stderr)
+	}
+	err = cmd.Run()
+	if ferr := flushOutput(cmd.Stderr); err == nil {
+		err = ferr
+	}
+	if !opts.keepGoing {
+		return nil, err
+	}
+	var exitErr *exec.ExitError
+	if err == nil || !errors.As(err, &exitErr) {
+		return nil, err
+	}
+	return findBuildFailures(opts, dir, flags, pkgs)
+}
+
+// goBuildArgs returns the arguments for a 'go build' of pkgs that doesn't write anything
+func goBuildArgs(flags []string, pkgs []string) []string {
+	args := []string{"build"}
+	if len(pkgs) == 1 {
+		// With more than one package, 'go build' discards the results by itself
+		args = append(args, "-o", "/dev/null")
+	}
+	args = append(args, flags...)
+	return append(args, pkgs...)
+}
+
+// findBuildFailures finds out which of pkgs and their dependencies fail to build, after 'go build'
+// failed: first those that can't be loaded, with 'go list -e', and then those that can't be
+// compiled, by building all the others.
+func findBuildFailures(opts cookOptions, dir string, flags []string, pkgs []string) ([]buildFailure, error) {
+	args := append([]string{"list", "-e", "-deps", "-json=ImportPath,Standard,Error,DepsErrors"}, opts.buildFlags()...)
+	cmd := opts.goCommand(append(args, pkgs...)...)
+	cmd.Dir = dir
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("could not list packages to find build failures: %w", err)
+	}
+
+	type packageError struct {
+		Err string
+	}
+	var failures []buildFailure
+	var buildable []string
+	dec := json.NewDecoder(&out)
+	for {
+		var p struct {
+			ImportPath string
+			Standard   bool
+			Error      *packageError
+			DepsErrors []*packageError
+		}
+		if err := dec.Decode(&p); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("could not parse 'go list' output: %w", err)
+		}
+		// Packages that only fail because of their dependencies are left out, so that each failure
+		// is reported once, for the package that causes it.
+		if p.Error != nil {
+			failures = append(failures, buildFailure{pkg: p.ImportPath, err: strings.TrimSpace(p.Error.Err)})
+		} else if len(p.DepsErrors) == 0 && !p.Standard {
+			buildable = append(buildable, p.ImportPath)
+		}
+	}
+	if len(buildable) == 0 {
+		return failures, nil
+	}
+
+	buildFlags, collectStats, err := opts.withActionGraph(slices.Clip(flags))
+	if err != nil {
+		return nil, err
+	}
+	defer collectStats()
+	cmd = opts.goCommand(goBuildArgs(buildFlags, buildable)...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, fmt.Errorf("could not run 'go build' command: %w", err)
+	} else if err != nil {
+		failures = append(failures, parseBuildErrors(stderr.String())...)
+	}
+	return failures, nil
+}
+
+// parseBuildErrors splits the output of a failed 'go build' into the errors of each package, which
+// it prints under a '# importpath' line. Output that isn't for any package in particular is
+// returned as a single failure without one.
+func parseBuildErrors(output string) []buildFailure {
+	var failures []buildFailure
+	var other []string
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if pkg, ok := strings.CutPrefix(line, "# "); ok {
+			// Test variants are printed as '# pkg [pkg.test]'
+			pkg, _, _ = strings.Cut(pkg, " ")
+			failures = append(failures, buildFailure{pkg: pkg})
+		} else if len(failures) != 0 {
+			f := &failures[len(failures)-1]
+			f.err = strings.TrimPrefix(f.err+"\n"+line, "\n")
+		} else if line != "" {
+			other = append(other, line)
+		}
+	}
+	if len(other) != 0 {
+		failures = append(failures, buildFailure{err: strings.Join(other, "\n")})
+	}
+	return failures
+}
+
+// reportBuildFailures warns about each package that couldn't be built, and with -strict, returns
+// an error if there were any.
+func reportBuildFailures(opts cookOptions, failures []buildFailure) error {
+	// The same package can fail for several modules, or in the debug build as well
+	var reported []buildFailure
+	for _, f := range failures {
+		if !slices.Contains(reported, f) {
+			reported = append(reported, f)
+		}
+	}
+	for _, f := range reported {
+		diag.packageFailed(f.pkg, f.err)
+	}
+	if len(reported) == 0 {
+		return nil
+	}
+	if opts.strict {
+		return fmt.Errorf("could not build %d package(s)", len(reported))
+	}
+	warnf("could not build %d package(s), but everything else was cooked", len(reported))
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// fullRecipe returns a recipe with every field set, including in the nested module recipes
+func fullRecipe() *recipe {
+	groups := []importGroup{
+		{Packages: []string{"example.com/a", "example.com/b"}},
+		{BuildConstraints: "linux && cgo", Packages: []string{"example.com/c"}, Cgo: true},
+	}
+	module := recipe{
+		ImportGroups:     groups,
+		TestImportGroups: groups[:1],
+		Platforms: map[string]platformRecipe{
+			"linux/amd64":  {ImportGroups: groups, TestImportGroups: groups[1:]},
+			"darwin/arm64": {ImportGroups: groups[:1]},
+		},
+		GoMod:           "module example.com/m\n",
+		GoSum:           "example.com/a v1.0.0 h1:a=\n",
+		OmitGoMod:       true,
+		GoVersion:       "1.22.0",
+		Toolchain:       "go1.22.4",
+		RequiredModules: []string{"example.com/a", "example.com/c"},
+		Tools:           []string{"example.com/a/cmd/gen", "example.com/d/cmd/x@v1.0.0"},
+		BuildFlags:      []string{"-trimpath", "-ldflags=-s -w"},
+		LocalReplaces:   []moduleRecipe{{Dir: "../local", recipe: recipe{ImportGroups: groups, GoMod: "module example.com/local\n"}}},
+		Env:             map[string]string{"GOOS": "linux", "GOARCH": "amd64", "CGO_ENABLED": "1"},
+		Metadata:        &recipeMetadata{GoChefVersion: "v0.1.0", ModulePath: "example.com/m", GoVersion: "go1.22.4", GitCommit: "0123abcd"},
+	}
+	r := module
+	r.Version = recipeVersion
+	r.Modules = []moduleRecipe{{Dir: "a", recipe: module}, {Dir: "b/c", recipe: recipe{ImportGroups: []importGroup{}}}}
+	r.GoWork = "go 1.22.0\n\nuse ./a\n"
+	r.GoWorkSum = "example.com/e v1.0.0 h1:e=\n"
+	return &r
+}
+
+func TestWriteRecipe(t *testing.T) {
+	full := fullRecipe()
+	// Every field has to be set, so that a field that's missing from writeRecipe is noticed
+	v := reflect.ValueOf(*full)
+	for i := range v.NumField() {
+		if f := v.Type().Field(i); f.IsExported() && v.Field(i).IsZero() {
+			t.Fatalf("fullRecipe doesn't set recipe.%s", f.Name)
+		}
+	}
+
+	tests := []struct {
+		name string
+		r    *recipe
+	}{
+		{"full", full},
+		{"minimal", &recipe{ImportGroups: []importGroup{}, GoMod: "module example.com/m\n"}},
+		{"single module", &fullRecipe().Modules[0].recipe},
+	}
+	for _, tt := range tests {
+		for _, pretty := range []bool{false, true} {
+			var want []byte
+			var err error
+			if pretty {
+				want, err = json.MarshalIndent(tt.r, "", "  ")
+			} else {
+				want, err = json.Marshal(tt.r)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want = append(want, '\n')
+
+			var got bytes.Buffer
+			if err := writeRecipe(&got, tt.r, pretty); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got.Bytes(), want) {
+				t.Errorf("%s, pretty=%v: writeRecipe() =\n%s\nwant (json.Marshal):\n%s", tt.name, pretty, got.Bytes(), want)
+			}
+
+			var roundTripped recipe
+			if err := json.Unmarshal(got.Bytes(), &roundTripped); err != nil {
+				t.Fatalf("%s, pretty=%v: %v", tt.name, pretty, err)
+			}
+			if !reflect.DeepEqual(&roundTripped, tt.r) {
+				t.Errorf("%s, pretty=%v: recipe changed in a round trip:\n%+v\nwant:\n%+v", tt.name, pretty, roundTripped, *tt.r)
+			}
+		}
+	}
+}
+
+func TestWriteRecipeNoImportGroups(t *testing.T) {
+	// Unlike json.Marshal, writeRecipe never writes null for importGroups, which the schema
+	// requires to be an array
+	var got bytes.Buffer
+	if err := writeRecipe(&got, &recipe{}, false); err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"importGroups":[],"go.mod":"","go.sum":""}` + "\n"; got.String() != want {
+		t.Errorf("writeRecipe() = %s, want %s", got.String(), want)
+	}
+}
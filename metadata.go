@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"runtime/debug"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// recipeMetadata describes where a recipe came from, to help with debugging. Cook ignores it, and
+// it's not part of the digest from -hash.
+type recipeMetadata struct {
+	// GoChefVersion is the module version of the go-chef binary that ran prepare
+	GoChefVersion string `json:"goChefVersion,omitempty"`
+	// ModulePath is the path of the prepared module, unless it's a workspace or -recursive
+	ModulePath string `json:"modulePath,omitempty"`
+	// GoVersion is the version of the go command, like "go1.23.1"
+	GoVersion string `json:"goVersion,omitempty"`
+	// GitCommit is the commit checked out in the source tree, only with -record-git-commit
+	GitCommit string `json:"gitCommit,omitempty"`
+}
+
+// prepareMetadata returns the metadata for the recipe. Anything that can't be determined is left
+// empty, with a warning for the git commit since that was explicitly requested.
+func prepareMetadata(r *recipe, opts prepareOptions) *recipeMetadata {
+	m := &recipeMetadata{}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		m.GoChefVersion = info.Main.Version
+	}
+	if r.GoMod != "" {
+		m.ModulePath = modfile.ModulePath([]byte(r.GoMod))
+	}
+
+	if env, err := readGoEnv(exec.Command("go"), []string{"GOVERSION"}); err == nil {
+		m.GoVersion = env["GOVERSION"]
+	}
+
+	if opts.recordGitCommit {
+		var out bytes.Buffer
+		cmd := exec.Command("git", "rev-parse", "HEAD")
+		cmd.Stdout = &out
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			warnf("could not get the git commit for -record-git-commit: %v", err)
+		} else {
+			m.GitCommit = strings.TrimSpace(out.String())
+		}
+	}
+	return m
+}
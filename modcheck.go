@@ -0,0 +1,140 @@
+package main
+
+import (
+	"go/build"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+)
+
+// checkImportResolution cross-references the imported packages against the requirements in go.mod,
+// warning about imports that 'go build' won't be able to resolve during cook:
+//
+//   - packages that aren't provided by any required module, usually because go.mod isn't tidy
+//   - packages that are provided by more than one required module (e.g. both cloud.google.com/go
+//     and cloud.google.com/go/storage), which fails with an "ambiguous import" error
+//
+// Ambiguity can only be detected by looking at the contents of the modules, so that check is only
+// done for modules that are already in the local module cache. Missing providers are only reported
+// for go 1.17 and later: before that, go.mod doesn't list the modules of indirect dependencies.
+func checkImportResolution(mf *modfile.File, groups []importGroup) {
+	modCache := moduleCacheDir()
+	// Without a go directive, the go command assumes go 1.16
+	listsIndirect := mf.Go != nil && compareGoVersions(mf.Go.Version, "1.17") >= 0
+
+	for _, g := range groups {
+		for _, pkg := range g.Packages {
+			if isStdPackage(pkg) {
+				continue
+			}
+
+			var providers []module.Version
+			for _, req := range mf.Require {
+				if pkg == req.Mod.Path || strings.HasPrefix(pkg, req.Mod.Path+"/") {
+					providers = append(providers, req.Mod)
+				}
+			}
+
+			switch len(providers) {
+			case 0:
+				if listsIndirect {
+					warnf("package %q is not provided by any module required in go.mod (is go.mod tidy?)", pkg)
+				}
+			case 1:
+				// all good
+			default:
+				var found []string
+				for _, m := range providers {
+					if moduleHasPackage(modCache, mf, m, pkg) {
+						found = append(found, m.String())
+					}
+				}
+				if len(found) > 1 {
+					warnf("ambiguous import: package %q is provided by multiple modules (%s)", pkg, strings.Join(found, ", "))
+				}
+			}
+		}
+	}
+}
+
+// isStdPackage returns whether the import path belongs to the standard library, using the same
+// rule as the go command: only non-standard import paths have a dot in their first element.
+func isStdPackage(pkg string) bool {
+	first, _, _ := strings.Cut(pkg, "/")
+	return !strings.Contains(first, ".")
+}
+
+// moduleCacheDir returns the directory of the module cache, without asking the go command
+func moduleCacheDir() string {
+	if dir := os.Getenv("GOMODCACHE"); dir != "" {
+		return dir
+	}
+	gopath, _, _ := strings.Cut(build.Default.GOPATH, string(filepath.ListSeparator))
+	return filepath.Join(gopath, "pkg", "mod")
+}
+
+// moduleHasPackage returns whether the module m (after replacements in go.mod) contains Go files
+// for the package, according to the module cache. It returns false if the module isn't in the
+// cache or has been replaced by a local directory.
+func moduleHasPackage(modCache string, mf *modfile.File, m module.Version, pkg string) bool {
+	target := m
+	for _, r := range mf.Replace {
+		if r.Old.Path == m.Path && (r.Old.Version == "" || r.Old.Version == m.Version) {
+			target = r.New
+		}
+	}
+	if target.Version == "" {
+		return false // local directory; not something we can see from here
+	}
+
+	escPath, err := module.EscapePath(target.Path)
+	if err != nil {
+		return false
+	}
+	escVersion, err := module.EscapeVersion(target.Version)
+	if err != nil {
+		return false
+	}
+	subdir := strings.TrimPrefix(strings.TrimPrefix(pkg, m.Path), "/")
+	dir := filepath.Join(modCache, escPath+"@"+escVersion, filepath.FromSlash(subdir))
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".go") && !strings.HasSuffix(e.Name(), "_test.go") {
+			return true
+		}
+	}
+	return false
+}
+
+// providingModules returns the sorted paths of the modules required in go.mod that provide the
+// imported packages. If more than one module provides a package, the one with the longest path
+// wins, like it would for nested modules.
+func providingModules(mf *modfile.File, groups []importGroup) []string {
+	var mods []string
+	for _, g := range groups {
+		for _, pkg := range g.Packages {
+			if isStdPackage(pkg) {
+				continue
+			}
+			var best string
+			for _, req := range mf.Require {
+				if (pkg == req.Mod.Path || strings.HasPrefix(pkg, req.Mod.Path+"/")) && len(req.Mod.Path) > len(best) {
+					best = req.Mod.Path
+				}
+			}
+			if best != "" && !slices.Contains(mods, best) {
+				mods = append(mods, best)
+			}
+		}
+	}
+	slices.Sort(mods)
+	return mods
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// optimizeLayer cleans up the build and module caches after cooking, so that the resulting Docker
+// layer is smaller and more reproducible. It removes:
+//
+//   - version control checkouts in GOMODCACHE/cache/vcs, which are only used while fetching
+//     modules directly from their origin
+//   - lock files and partial downloads in GOMODCACHE/cache/download
+//   - go-build* temporary directories left behind by interrupted go commands of the cook, which
+//     are all in its own GOTMPDIR
+//
+// If SOURCE_DATE_EPOCH is set, the modification times of everything in both caches are also set
+// to that time, so that identical cooks produce identical layers.
+//
+// The go command uses these times to decide which build cache entries are stale: when it trims
+// the cache, it deletes the entries that weren't used for 5 days, except those it has just used
+// itself. GOCACHE/trim.txt, which records when the cache was last trimmed, is kept, since without
+// it the very next go command would trim -- and with SOURCE_DATE_EPOCH more than 5 days in the
+// past, evict everything cooked that it doesn't need. It only trims once a day, though, so a layer
+// that's used more than a day after it was cooked still loses the entries the first build doesn't
+// need.
+func optimizeLayer(opts cookOptions) error {
+	cmd := opts.goCommand("env", "-json", "GOCACHE", "GOMODCACHE")
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("could not get cache locations from 'go env': %w", err)
+	}
+	var env struct {
+		GOCACHE    string
+		GOMODCACHE string
+	}
+	if err := json.Unmarshal(out.Bytes(), &env); err != nil {
+		return fmt.Errorf("could not parse 'go env' output: %w", err)
+	}
+
+	var errs []error
+	if env.GOMODCACHE != "" {
+		errs = append(errs, os.RemoveAll(filepath.Join(env.GOMODCACHE, "cache", "vcs")))
+
+		downloads := filepath.Join(env.GOMODCACHE, "cache", "download")
+		err := filepath.WalkDir(downloads, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			name := d.Name()
+			if !d.IsDir() && (strings.HasSuffix(name, ".lock") || strings.HasSuffix(name, ".partial") || strings.HasSuffix(name, ".tmp")) {
+				return os.Remove(path)
+			}
+			return nil
+		})
+		errs = append(errs, err)
+	}
+	if opts.goTmpDir != "" {
+		errs = append(errs, os.RemoveAll(opts.goTmpDir))
+	}
+
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		secs, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid SOURCE_DATE_EPOCH %q: %w", epoch, err)
+		}
+		t := time.Unix(secs, 0)
+		for _, dir := range []string{env.GOCACHE, env.GOMODCACHE} {
+			if dir != "" && dir != "off" {
+				errs = append(errs, setModTimes(dir, t))
+			}
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("could not optimize cache layer: %w", err)
+	}
+	return nil
+}
+
+// setModTimes sets the access and modification times of every file and directory under root
+// (except symlinks) to t
+func setModTimes(root string, t time.Time) error {
+	var dirs []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		if d.IsDir() {
+			// Changing a directory's entries updates its modification time, so directories are
+			// done last.
+			dirs = append(dirs, path)
+			return nil
+		}
+		return os.Chtimes(path, t, t)
+	})
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if err := os.Chtimes(dir, t, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
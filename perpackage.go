@@ -0,0 +1,57 @@
+package main
+
+import (
+	"slices"
+)
+
+// perPackageBatchSize is how many packages -per-package passes to a single 'go build'. Each
+// invocation has to load its packages first, so building them one by one would be much slower.
+const perPackageBatchSize = 64
+
+// cookPackages returns the recorded packages of the module that are imported when building for the
+// target of the cook, for -per-package to build directly.
+//
+// Unlike the generated files, the packages aren't behind the build constraints of the files
+// importing them, so the import groups that wouldn't be built are skipped here instead.
+func cookPackages(opts cookOptions, m moduleRecipe) []string {
+	target := opts.cookTarget()
+	var pkgs []string
+	for _, g := range m.cookImportGroups() {
+		if !target.matches(g.BuildConstraints) {
+			continue
+		}
+		for _, pkg := range g.Packages {
+			if !slices.Contains(pkgs, pkg) {
+				pkgs = append(pkgs, pkg)
+			}
+		}
+	}
+	slices.Sort(pkgs)
+	return pkgs
+}
+
+// goBuildBatches builds pkgs like goBuild, but in batches of perPackageBatchSize, up to -j of which
+// run in parallel. The failures of all batches are collected, and so are their errors.
+func goBuildBatches(opts cookOptions, dir string, flags []string, pkgs []string) ([]buildFailure, error) {
+	var batches [][]string
+	for len(pkgs) > perPackageBatchSize {
+		batches = append(batches, pkgs[:perPackageBatchSize])
+		pkgs = pkgs[perPackageBatchSize:]
+	}
+	if len(pkgs) != 0 {
+		batches = append(batches, pkgs)
+	}
+
+	batchFailures := make([][]buildFailure, len(batches))
+	err := runParallel(opts.jobs, len(batches), func(i int) error {
+		var err error
+		batchFailures[i], err = goBuild(opts, dir, flags, batches[i])
+		return err
+	})
+
+	var failures []buildFailure
+	for _, f := range batchFailures {
+		failures = append(failures, f...)
+	}
+	return failures, err
+}
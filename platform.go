@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// platformRecipe is the part of a recipe that's specific to one platform, with -platforms
+type platformRecipe struct {
+	ImportGroups     []importGroup `json:"importGroups"`
+	TestImportGroups []importGroup `json:"testImportGroups,omitempty"`
+}
+
+// parsePlatform splits a platform like "linux/amd64" into its GOOS and GOARCH
+func parsePlatform(platform string) (goos, goarch string, err error) {
+	goos, goarch, ok := strings.Cut(platform, "/")
+	if !ok || !knownOS[goos] || !knownArch[goarch] {
+		return "", "", fmt.Errorf("error: Invalid platform %q, must be GOOS/GOARCH like 'linux/amd64'", platform)
+	}
+	return goos, goarch, nil
+}
+
+// platformList returns the platforms given to -platforms
+func (opts prepareOptions) platformList() []string {
+	var platforms []string
+	for _, p := range strings.Split(opts.platforms, ",") {
+		if p = strings.TrimSpace(p); p != "" && !slices.Contains(platforms, p) {
+			platforms = append(platforms, p)
+		}
+	}
+	return platforms
+}
+
+// defaultCookTarget returns the GOOS and GOARCH to cook for when -goos and -goarch aren't given:
+// the TARGETOS and TARGETARCH variables that 'docker buildx' provides as build arguments, unless
+// GOOS and GOARCH themselves are set. An empty value means the go command's default.
+func defaultCookTarget() (goos, goarch string) {
+	if os.Getenv("GOOS") == "" {
+		goos = os.Getenv("TARGETOS")
+	}
+	if os.Getenv("GOARCH") == "" {
+		goarch = os.Getenv("TARGETARCH")
+	}
+	return goos, goarch
+}
+
+// hasPlatforms returns whether the recipe, or any module in it, has per-platform sections
+func (r *recipe) hasPlatforms() bool {
+	if len(r.Platforms) != 0 {
+		return true
+	}
+	for _, m := range r.Modules {
+		if m.hasPlatforms() {
+			return true
+		}
+	}
+	for _, m := range r.LocalReplaces {
+		if m.hasPlatforms() {
+			return true
+		}
+	}
+	return false
+}
+
+// selectPlatform replaces the import groups of the recipe, and of every module in it, with the
+// ones of the platform's section.
+func (r *recipe) selectPlatform(platform string) error {
+	if len(r.Platforms) != 0 {
+		section, ok := r.Platforms[platform]
+		if !ok {
+			var available []string
+			for p := range r.Platforms {
+				available = append(available, p)
+			}
+			slices.Sort(available)
+			return fmt.Errorf("error: Recipe has no section for platform %q, only for %s", platform, strings.Join(available, ", "))
+		}
+		r.ImportGroups = section.ImportGroups
+		r.TestImportGroups = section.TestImportGroups
+		r.Platforms = nil
+	}
+	for i := range r.Modules {
+		if err := r.Modules[i].selectPlatform(platform); err != nil {
+			return err
+		}
+	}
+	for i := range r.LocalReplaces {
+		if err := r.LocalReplaces[i].selectPlatform(platform); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,731 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/build/constraint"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"golang.org/x/mod/modfile"
+)
+
+type prepareOptions struct {
+	json         bool
+	gowork       string
+	includeTests bool
+	// includeIgnored records the imports of files marked //go:build ignore in the unconstrained
+	// group, instead of skipping those files
+	includeIgnored bool
+	// toolsTag is the build tag of tools.go files, whose imports are recorded as tools
+	toolsTag string
+	// includeGenerators records the code generators run by //go:generate directives as tools
+	includeGenerators bool
+	// mode is how imports are found: by parsing source files (prepareModeAST), or with 'go list'
+	mode string
+	// goos, goarch, and tags set the build configuration to record imports for. Without any of
+	// them, -mode=ast records imports for every configuration.
+	goos, goarch, tags string
+	// buildFlags are the flags for 'go build' to record in the recipe, see passthroughFlags
+	buildFlags []string
+	// trimGoSum drops go.sum entries that can't be needed for the recorded packages
+	trimGoSum bool
+	// omitGoMod leaves go.mod and go.sum out of the recipe, for cook to read from the source tree
+	omitGoMod bool
+	// minimizeGoMod rewrites go.mod to only the requirements needed for the recorded packages
+	minimizeGoMod bool
+	// granularity is what the recipe records: individual packages (granularityPackage), or just
+	// the modules providing them (granularityModule)
+	granularity string
+	// recordGitCommit adds the checked out git commit to the recipe's metadata
+	recordGitCommit bool
+	// tiers are the package patterns of each tier to split out of the recipe, see tierPatterns
+	tiers string
+	// compress is the compression of the recipe file, if any
+	compress string
+	// pretty indents the recipe JSON
+	pretty bool
+	// hash prints the digest of the recipe to stdout, and hashFile writes it to a file
+	hash     bool
+	hashFile string
+	// platforms is the comma-separated list of platforms to record imports for separately
+	platforms string
+	// skipDirs is a comma-separated list of directory names that aren't scanned, wherever they are
+	skipDirs string
+	// recursive prepares every module in or below the current directory, instead of just one
+	recursive bool
+	// stripLocalReplaces drops replace directives that point to local directories from go.mod,
+	// together with the modules they replace
+	stripLocalReplaces bool
+
+	// moduleDir is the directory of the module on disk, if it is on disk. It's needed for
+	// -mode=golist.
+	moduleDir string
+	// workspaceModules, if not empty, are the paths of all modules in the workspace being prepared.
+	// Imports of their packages are local, just like the module's own.
+	workspaceModules []string
+}
+
+func runPrepare(recipePath string, opts prepareOptions) error {
+	var goWork string
+	if opts.gowork != "off" {
+		var err error
+		if goWork, err = findGoWork("."); err != nil {
+			return fmt.Errorf("could not check for go.work: %w", err)
+		}
+	}
+
+	var r *recipe
+	if opts.tiers != "" && (opts.recursive || goWork != "") {
+		return errors.New("error: Cannot use -tiers with -recursive or in workspace mode")
+	}
+	if opts.recursive {
+		if goWork != "" {
+			return fmt.Errorf("error: Cannot use -recursive in workspace mode (from %s). Pass -gowork=off to both -prepare and -cook to ignore the workspace", goWork)
+		}
+		var err error
+		if r, err = prepareRecursive(".", opts); err != nil {
+			return err
+		}
+	} else if goWork != "" {
+		var err error
+		if r, err = prepareWorkspace(goWork, opts); err != nil {
+			return err
+		}
+	} else {
+		// Like the go command, this works from any directory inside the module -- the whole module
+		// is scanned either way.
+		root, err := findModuleRoot(".")
+		if err != nil {
+			return err
+		}
+		if r, err = prepareModuleDir(root, opts); err != nil {
+			return err
+		}
+	}
+	env, err := prepareEnv(opts)
+	if err != nil {
+		return fmt.Errorf("could not get build environment: %w", err)
+	}
+	r.Env = env
+	r.BuildFlags = opts.buildFlags
+	r.Version = recipeVersion
+	r.Metadata = prepareMetadata(r, opts)
+	diag.prepareDone(r.allImportGroups())
+
+	if err := writeRecipeFile(recipePath, r, opts); err != nil {
+		return err
+	}
+	for i, t := range r.tiers {
+		t.Env, t.BuildFlags, t.Version, t.Metadata = r.Env, r.BuildFlags, r.Version, r.Metadata
+		if err := writeRecipeFile(tierRecipePath(recipePath, i), t, opts); err != nil {
+			return err
+		}
+	}
+
+	if !opts.hash && opts.hashFile == "" {
+		return nil
+	}
+	// The recipe is hashed as written before compression, but without the metadata or the build
+	// environment of the machine it was prepared on. Neither changes what cook builds, and the hash
+	// must be the same on every machine for the same dependencies. It's always the compact
+	// encoding, so -pretty doesn't change it either. That's deterministic: import groups, tools and
+	// modules are sorted, and maps are encoded with sorted keys.
+	//
+	// With -tiers, the tier recipes are hashed along with it, one after the other, so that a single
+	// digest covers every file that's cooked.
+	h := sha256.New()
+	for _, t := range append([]*recipe{r}, r.tiers...) {
+		hashed := *t
+		hashed.Metadata, hashed.Env = nil, nil
+		if err := writeRecipe(h, &hashed, false); err != nil {
+			return fmt.Errorf("could not hash recipe: %w", err)
+		}
+	}
+	digest := "sha256:" + hex.EncodeToString(h.Sum(nil))
+	if opts.hash {
+		fmt.Println(digest)
+	}
+	if opts.hashFile != "" {
+		if err := os.WriteFile(opts.hashFile, []byte(digest+"\n"), 0o666); err != nil {
+			return fmt.Errorf("could not write recipe hash to file %s: %w", opts.hashFile, err)
+		}
+	}
+	return nil
+}
+
+// writeRecipeFile writes the recipe to recipePath, or to stdout for recipeStdio
+func writeRecipeFile(recipePath string, r *recipe, opts prepareOptions) error {
+	var f io.WriteCloser = nopWriteCloser{os.Stdout}
+	if recipePath != recipeStdio {
+		var err error
+		if f, err = os.OpenFile(recipePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o777); err != nil {
+			return fmt.Errorf("could not write recipe to file %s: %w", recipePath, err)
+		}
+	}
+	zw, err := newCompressWriter(f, opts.compress)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := writeRecipe(zw, r, opts.pretty); err != nil {
+		f.Close()
+		return fmt.Errorf("could not write recipe to file %s: %w", recipePath, err)
+	}
+	if err := zw.Close(); err != nil {
+		f.Close()
+		return fmt.Errorf("could not write recipe to file %s: %w", recipePath, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("could not write recipe to file %s: %w", recipePath, err)
+	}
+	return nil
+}
+
+// prepareRecipe produces the recipe for the module whose root directory is fsys.
+//
+// All reads go through fsys, so the source tree doesn't need to be on disk -- it can just as well
+// come from a tarball (via an fs.FS over the archive), an embed.FS, or an fstest.MapFS.
+func prepareRecipe(fsys fs.FS, opts prepareOptions) (*recipe, error) {
+	// Parse the go.mod file to get the name of the module -- that way, we can filter out packages
+	// that are *not* part of this one.
+	modContents, err := fs.ReadFile(fsys, "go.mod")
+	if err != nil {
+		return nil, fmt.Errorf("could not read go.mod: %w", err)
+	}
+	mf, err := modfile.Parse("go.mod", modContents, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse go.mod: %w", err)
+	}
+	// name of the module, like 'github.com/foo/bar' or 'example.com/baz'
+	moduleName := mf.Module.Mod.Path
+
+	checkVendorConsistency(fsys, mf)
+
+	// Read the contents of go.sum, just to store it for later.
+	sumContents, err := fs.ReadFile(fsys, "go.sum")
+	if err != nil {
+		return nil, fmt.Errorf("could not read go.sum: %w", err)
+	}
+
+	// Packages of other modules in the workspace are local, and so are the packages of modules
+	// replaced with local directories -- cook never has their source.
+	localModules := slices.Clip(opts.workspaceModules)
+	for _, rep := range localReplaces(mf) {
+		localModules = append(localModules, rep.Old.Path)
+	}
+	if opts.stripLocalReplaces {
+		if modContents, sumContents, err = stripLocalReplaces(mf, modContents, sumContents); err != nil {
+			return nil, err
+		}
+	}
+
+	r := &recipe{
+		GoMod: string(modContents),
+		GoSum: string(sumContents),
+	}
+	// With -platforms, every platform is scanned separately, as if with -goos and -goarch
+	var builder *importsBuilder
+	var generators []string
+	addPlatform := func(opts prepareOptions) (*platformRecipe, error) {
+		b := newImportsBuilder(moduleName, localModules, opts)
+		// Imports from _test.go files are kept separately, only used with -include-tests
+		testBuilder := newImportsBuilder(moduleName, localModules, opts)
+		var err error
+		if opts.mode == prepareModeGoList {
+			err = listImports(opts, b, testBuilder)
+		} else {
+			err = scanImports(fsys, opts, b, testBuilder)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not scan source files: %w", err)
+		}
+		r.Tools = append(r.Tools, b.toolPackages()...)
+		r.Tools = append(r.Tools, testBuilder.toolPackages()...)
+		generators = append(generators, b.generators...)
+		generators = append(generators, testBuilder.generators...)
+		builder = b
+		return &platformRecipe{
+			ImportGroups:     b.importGroups(),
+			TestImportGroups: testBuilder.importGroups(),
+		}, nil
+	}
+	if platforms := opts.platformList(); len(platforms) != 0 {
+		r.Platforms = make(map[string]platformRecipe)
+		for _, platform := range platforms {
+			popts := opts
+			if popts.goos, popts.goarch, err = parsePlatform(platform); err != nil {
+				return nil, err
+			}
+			p, err := addPlatform(popts)
+			if err != nil {
+				return nil, err
+			}
+			r.Platforms[platform] = *p
+		}
+	} else {
+		p, err := addPlatform(opts)
+		if err != nil {
+			return nil, err
+		}
+		r.ImportGroups = p.ImportGroups
+		r.TestImportGroups = p.TestImportGroups
+	}
+
+	if mf.Go != nil {
+		r.GoVersion = mf.Go.Version
+	}
+	if mf.Toolchain != nil {
+		r.Toolchain = mf.Toolchain.Name
+	}
+	for _, t := range mf.Tool {
+		// The module's own tools are built from source that cook doesn't have
+		if !builder.isLocal(t.Path) {
+			r.Tools = append(r.Tools, t.Path)
+		}
+	}
+	r.Tools = append(r.Tools, resolveGenerators(builder, mf, generators)...)
+	slices.Sort(r.Tools)
+	r.Tools = slices.Compact(r.Tools)
+	allGroups := r.allImportGroups()
+	warnUnsatisfiableGroups(allGroups)
+	checkImportResolution(mf, allGroups)
+
+	// With -tiers, each tier becomes a separate recipe with the packages matching its patterns,
+	// which are then left out of the later tiers and the main recipe
+	for _, patterns := range opts.tierPatterns() {
+		t := splitTier(r, patterns)
+		if err := finishRecipe(t, mf, localModules, opts); err != nil {
+			return nil, err
+		}
+		r.tiers = append(r.tiers, t)
+	}
+	if err := finishRecipe(r, mf, localModules, opts); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// finishRecipe applies the options that depend on the final set of packages in the recipe:
+// -trim-gosum, -minimize-gomod, and -granularity=module.
+func finishRecipe(r *recipe, mf *modfile.File, localModules []string, opts prepareOptions) error {
+	allGroups := r.allImportGroups()
+
+	if opts.trimGoSum {
+		sum, err := trimGoSum(opts, mf, allGroups, r.Tools, []byte(r.GoSum))
+		if err != nil {
+			return err
+		}
+		r.GoSum = string(sum)
+	}
+
+	if opts.minimizeGoMod {
+		mod, err := minimizeGoMod(opts, mf, localModules, allGroups, r.Tools)
+		if err != nil {
+			return err
+		}
+		r.GoMod = string(mod)
+	}
+
+	if opts.granularity == granularityModule {
+		r.RequiredModules = providingModules(mf, allGroups)
+		r.ImportGroups = nil
+		r.TestImportGroups = nil
+	}
+	return nil
+}
+
+// scanImports parses every Go source file in fsys, adding their imports to builder -- or for
+// _test.go files, to testBuilder.
+func scanImports(fsys fs.FS, opts prepareOptions, builder, testBuilder *importsBuilder) error {
+	skipDirs := opts.skipDirList()
+
+	return fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		filename := d.Name()
+		isGoFile := !d.IsDir() && strings.HasSuffix(filename, ".go")
+		// Skip hidden files/directories
+		if strings.HasPrefix(filename, ".") && filename != "." {
+			if d.IsDir() {
+				diag.fileSkipped(path, "hidden directory")
+				return fs.SkipDir
+			} else {
+				if isGoFile {
+					diag.fileSkipped(path, "hidden file")
+				}
+				return nil
+			}
+		}
+		// Skip vendored copies of dependencies, test fixtures, etc.
+		if d.IsDir() && path != "." && slices.Contains(skipDirs, filename) {
+			diag.fileSkipped(path, "skipped directory")
+			return fs.SkipDir
+		}
+		// Subdirectories with their own go.mod are separate modules, which 'go build ./...' doesn't
+		// include either.
+		if d.IsDir() && path != "." {
+			if _, err := fs.Stat(fsys, path+"/go.mod"); err == nil {
+				diag.fileSkipped(path, "nested module")
+				return fs.SkipDir
+			}
+		}
+		// Parse all files ending in ".go":
+		if isGoFile {
+			b := builder
+			if strings.HasSuffix(filename, "_test.go") {
+				if !opts.includeTests {
+					diag.fileSkipped(path, "test file")
+					return nil
+				}
+				b = testBuilder
+			}
+			if err := b.addFile(fsys, path); errors.Is(err, errIgnoredFile) {
+				diag.fileSkipped(path, "marked //go:build ignore")
+				return nil
+			} else if errors.Is(err, errExcludedFile) {
+				diag.fileSkipped(path, "not built for target")
+				return nil
+			} else if err != nil {
+				return err
+			}
+			diag.fileParsed(path)
+		}
+		return nil
+	})
+}
+
+// skipDirList returns the names of the directories that are skipped while scanning
+func (opts prepareOptions) skipDirList() []string {
+	var skipDirs []string
+	for _, name := range strings.Split(opts.skipDirs, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			skipDirs = append(skipDirs, name)
+		}
+	}
+	return skipDirs
+}
+
+type importsBuilder struct {
+	modName        string
+	modPrefix      string
+	localModules   []string
+	includeIgnored bool
+	toolsTag       string
+	imports        map[string]map[string]struct{}
+	// tools are the imports of tools.go files
+	tools map[string]struct{}
+	// generators are the commands run by //go:generate directives, only with -include-generators
+	generators        []string
+	includeGenerators bool
+	// cgo records which import groups came from files that import "C"
+	cgo map[string]bool
+	// target is the build configuration files are evaluated against, if any. All imports of
+	// matching files are then recorded without build constraints.
+	target *buildTarget
+}
+
+func newImportsBuilder(modName string, localModules []string, opts prepareOptions) *importsBuilder {
+	return &importsBuilder{
+		modName:           modName,
+		modPrefix:         fmt.Sprintf("%s/", modName),
+		localModules:      localModules,
+		includeIgnored:    opts.includeIgnored,
+		toolsTag:          opts.toolsTag,
+		includeGenerators: opts.includeGenerators,
+		imports:           make(map[string]map[string]struct{}),
+		tools:             make(map[string]struct{}),
+		cgo:               make(map[string]bool),
+		target:            opts.buildTarget(),
+	}
+}
+
+// buildTarget returns the build configuration that prepare evaluates the files' build constraints
+// against, or nil to record imports for every configuration instead. With -mode=golist, 'go list'
+// already only returns the imports for the target.
+func (opts prepareOptions) buildTarget() *buildTarget {
+	if opts.mode != prepareModeAST || (opts.goos == "" && opts.goarch == "" && opts.tags == "") {
+		return nil
+	}
+	return newBuildTarget(opts.goos, opts.goarch, opts.tags)
+}
+
+// isLocal returns whether the package is part of the module itself, or of any of the other local
+// modules
+func (b *importsBuilder) isLocal(pkg string) bool {
+	if pkg == b.modName || strings.HasPrefix(pkg, b.modPrefix) {
+		return true
+	}
+	return slices.ContainsFunc(b.localModules, func(mod string) bool {
+		return pkg == mod || strings.HasPrefix(pkg, mod+"/")
+	})
+}
+
+// errIgnoredFile is returned by addFile for files that require the "ignore" build tag, which are
+// skipped unless -include-ignored is given
+var errIgnoredFile = errors.New("file is marked //go:build ignore")
+
+// errExcludedFile is returned by addFile for files that aren't built for the target configuration
+// given by -goos, -goarch, and -tags
+var errExcludedFile = errors.New("file is not built for the target")
+
+func (b *importsBuilder) addFile(fsys fs.FS, filepath string) error {
+	src, err := fs.ReadFile(fsys, filepath)
+	if err != nil {
+		return fmt.Errorf("failed to read file at %q: %w", filepath, err)
+	}
+	src, err = normalizeSource(src)
+	if err != nil {
+		return fmt.Errorf("file at %q is not valid Go source: %w", filepath, err)
+	}
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filepath, src, parser.ImportsOnly|parser.ParseComments)
+	if err != nil {
+		return fmt.Errorf("failed to parse file at %q: %w", filepath, err)
+	}
+
+	// 'go generate' skips ignored files too. This has to happen before the fast path below, since
+	// the directives can be in files that don't import anything.
+	if b.includeGenerators && !requiresTag(extractBuildConstraints(file), ignoreBuildTag) &&
+		b.target.matches(withFilenameConstraint(extractBuildConstraints(file), path.Base(filepath))) {
+		b.generators = append(b.generators, generatorCommands(src)...)
+	}
+
+	// Fast path: don't do anything if the file doesn't import anything
+	if len(file.Imports) == 0 {
+		return nil
+	}
+
+	// figure out which import group is accurate for this file based on whether it has a //go:build comment,
+	// and any GOOS/GOARCH suffixes in its name
+	buildConstraints := withFilenameConstraint(extractBuildConstraints(file), path.Base(filepath))
+	// Files that import "C" are only built with cgo enabled, as if they had an extra "cgo" tag
+	usesCgo := slices.ContainsFunc(file.Imports, func(spec *ast.ImportSpec) bool {
+		return spec.Path.Value == `"C"`
+	})
+	if usesCgo {
+		buildConstraints = andConstraint(buildConstraints, &constraint.TagExpr{Tag: "cgo"})
+	}
+	buildConstraints = canonicalConstraint(buildConstraints)
+
+	// Files like generator scripts are excluded from the build with //go:build ignore, so their
+	// imports would end up in a group that's never built.
+	if requiresTag(buildConstraints, ignoreBuildTag) {
+		if !b.includeIgnored {
+			return errIgnoredFile
+		}
+		buildConstraints = ""
+	}
+
+	// tools.go files blank-import the tools that the module depends on, behind a build tag that's
+	// never set -- so their imports are recorded as tools, which cook builds explicitly.
+	isTools := b.toolsTag != "" && requiresTag(buildConstraints, b.toolsTag)
+
+	// With a target configuration, only files built for it count, and the result is a single group
+	if b.target != nil && !isTools {
+		if !b.target.matches(buildConstraints) {
+			return errExcludedFile
+		}
+		buildConstraints = ""
+	}
+
+	ig := b.imports[buildConstraints]
+	if isTools {
+		ig = b.tools
+	} else if ig == nil {
+		ig = make(map[string]struct{})
+	}
+
+	for _, spec := range file.Imports {
+		pkg, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			return fmt.Errorf("failed to unquote %s : %w", spec.Path.Value, err)
+		}
+		// External test packages often import the module's root package, so that needs to be
+		// excluded as well. "C" isn't a real package, and can't be imported without a preamble.
+		if pkg != "C" && !b.isLocal(pkg) {
+			ig[pkg] = struct{}{}
+		}
+	}
+
+	if !isTools {
+		b.imports[buildConstraints] = ig
+		if usesCgo {
+			b.cgo[buildConstraints] = true
+		}
+	}
+
+	return nil
+}
+
+// addImports adds the imported packages to the group with the given build constraints, skipping
+// the ones that are local
+func (b *importsBuilder) addImports(buildConstraints string, pkgs []string, usesCgo bool) {
+	ig := b.imports[buildConstraints]
+	if ig == nil {
+		ig = make(map[string]struct{})
+		b.imports[buildConstraints] = ig
+	}
+	for _, pkg := range pkgs {
+		if pkg != "C" && !b.isLocal(pkg) {
+			ig[pkg] = struct{}{}
+		}
+	}
+	if usesCgo {
+		b.cgo[buildConstraints] = true
+	}
+}
+
+// toolPackages returns the sorted imports of tools.go files
+func (b *importsBuilder) toolPackages() []string {
+	pkgs := make([]string, 0, len(b.tools))
+	for pkg := range b.tools {
+		pkgs = append(pkgs, pkg)
+	}
+	slices.Sort(pkgs)
+	return pkgs
+}
+
+// normalizeSource undoes the encoding quirks that some editors and code generators introduce, which
+// the Go compiler may tolerate but go/parser doesn't -- or that would otherwise make us misread
+// the file's build constraints:
+//
+//   - A leading UTF-8 byte order mark is removed.
+//   - UTF-16 files (detected by their byte order mark) are converted to UTF-8.
+//   - Files that only use old Mac-style "\r" line endings are converted to "\n", so that line
+//     comments like //go:build end where they're supposed to. Files with any "\n" are left alone,
+//     since a stray "\r" there is harmless.
+//
+// Anything that's still not valid UTF-8 afterwards is reported as an error.
+func normalizeSource(src []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(src, []byte{0xEF, 0xBB, 0xBF}):
+		src = src[3:]
+	case bytes.HasPrefix(src, []byte{0xFF, 0xFE}):
+		src = decodeUTF16(src[2:], binary.LittleEndian)
+	case bytes.HasPrefix(src, []byte{0xFE, 0xFF}):
+		src = decodeUTF16(src[2:], binary.BigEndian)
+	}
+
+	if !bytes.ContainsRune(src, '\n') && bytes.ContainsRune(src, '\r') {
+		src = bytes.ReplaceAll(src, []byte{'\r'}, []byte{'\n'})
+	}
+
+	if !utf8.Valid(src) {
+		return nil, errors.New("contents are not UTF-8 encoded")
+	}
+	return src, nil
+}
+
+func decodeUTF16(b []byte, order binary.ByteOrder) []byte {
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = order.Uint16(b[2*i:])
+	}
+	return []byte(string(utf16.Decode(units)))
+}
+
+// https://pkg.go.dev/cmd/go#hdr-Build_constraints
+//
+// Only //go:build lines before the package clause count; anything later is just a comment.
+func extractBuildConstraints(file *ast.File) string {
+	buildPrefix := "//go:build "
+	for _, cg := range file.Comments {
+		if cg.Pos() > file.Package {
+			break
+		}
+		for _, c := range cg.List {
+			if constraint.IsGoBuild(c.Text) {
+				return strings.TrimSpace(strings.TrimPrefix(c.Text, buildPrefix))
+			}
+		}
+	}
+	return "" // no build constraints
+}
+
+func (b *importsBuilder) importGroups() []importGroup {
+	// we're sorting the lists before returning so that this method is deterministic
+	//
+	// Each set is removed from the builder as soon as it's been converted, so that we don't hold
+	// both copies of the (potentially very large) package lists at the same time.
+	groups := make([]importGroup, 0, len(b.imports))
+	for buildConstraints, group := range b.imports {
+		pkgs := make([]string, 0, len(group))
+		for pkgName := range group {
+			pkgs = append(pkgs, pkgName)
+		}
+		delete(b.imports, buildConstraints)
+		slices.Sort(pkgs)
+		groups = append(groups, importGroup{
+			BuildConstraints: buildConstraints,
+			Packages:         pkgs,
+			Cgo:              b.cgo[buildConstraints],
+		})
+	}
+
+	slices.SortFunc(groups, func(gx, gy importGroup) int {
+		if gx.BuildConstraints < gy.BuildConstraints {
+			return -1
+		} else {
+			return 1
+		}
+	})
+
+	return mergeEquivalentGroups(groups)
+}
+
+// mergeEquivalentGroups merges import groups whose build constraints are logically the same, even
+// if they're written differently (e.g. "a && (b || c)" and "(a && b) || (a && c)"). The merged
+// group keeps the constraint that sorts first.
+func mergeEquivalentGroups(groups []importGroup) []importGroup {
+	merged := groups[:0]
+	for _, g := range groups {
+		i := slices.IndexFunc(merged, func(m importGroup) bool {
+			return equivalentConstraints(m.BuildConstraints, g.BuildConstraints)
+		})
+		if i == -1 {
+			merged = append(merged, g)
+			continue
+		}
+		pkgs := append(merged[i].Packages, g.Packages...)
+		slices.Sort(pkgs)
+		merged[i].Packages = slices.Compact(pkgs)
+		merged[i].Cgo = merged[i].Cgo || g.Cgo
+	}
+	return merged
+}
+
+// warnUnsatisfiableGroups prints a warning for each import group whose build constraints can never
+// be satisfied. Those packages won't ever be built by cook, and it's usually a sign of a typo in a
+// build tag somewhere (or a bug in prepare).
+func warnUnsatisfiableGroups(groups []importGroup) {
+	for _, g := range groups {
+		if g.BuildConstraints == "" {
+			continue
+		}
+		x, err := constraint.Parse("//go:build " + g.BuildConstraints)
+		if err != nil {
+			warnf("could not parse build constraint %q: %s", g.BuildConstraints, err)
+			continue
+		}
+		if !constraintSatisfiable(x) {
+			warnf("build constraint %q can never be satisfied on any platform, so its %d package(s) will never be cooked", g.BuildConstraints, len(g.Packages))
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"slices"
+	"strings"
+)
+
+// buildProgress receives the stderr of a 'go build -v' during a cook with -v, reporting each
+// package that it compiles. Everything else, like build errors, is passed on to w.
+type buildProgress struct {
+	w io.Writer
+	// deps are all the packages the build can compile, from 'go list -deps', except for the
+	// generated main package, which is in skip
+	deps map[string]bool
+	skip map[string]bool
+	// compiled is how many of them have been compiled so far
+	compiled int
+	partial  []byte
+}
+
+// newBuildProgress lists the dependencies of pkgs (built with flags in dir) for a buildProgress.
+// If they can't be listed, progress is still reported, just without the total.
+//
+// When the generated main package is built (pkgs is "."), it isn't reported or counted, since it's
+// not a dependency: only the packages it imports, which 'go list' marks as DepOnly.
+func newBuildProgress(opts cookOptions, dir string, flags []string, pkgs []string, w io.Writer) *buildProgress {
+	args := append([]string{"list", "-e", "-deps", "-f", "{{.ImportPath}} {{.DepOnly}}"}, flags...)
+	cmd := opts.goCommand(append(args, pkgs...)...)
+	cmd.Dir = dir
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = io.Discard
+	generated := slices.Equal(pkgs, []string{"."})
+	p := &buildProgress{w: w, deps: make(map[string]bool), skip: make(map[string]bool)}
+	if cmd.Run() == nil {
+		for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			pkg, depOnly, _ := strings.Cut(line, " ")
+			if generated && depOnly != "true" {
+				p.skip[pkg] = true
+			} else if pkg != "" {
+				p.deps[pkg] = true
+			}
+		}
+	}
+	return p
+}
+
+func (p *buildProgress) Write(b []byte) (int, error) {
+	p.partial = append(p.partial, b...)
+	for {
+		i := bytes.IndexByte(p.partial, '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+		line := string(p.partial[:i])
+		p.partial = p.partial[i+1:]
+		if err := p.writeLine(line); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Flush handles what's left of the output after the build, if it didn't end with a newline, and
+// flushes w
+func (p *buildProgress) Flush() error {
+	if len(p.partial) != 0 {
+		line := string(p.partial)
+		p.partial = nil
+		if err := p.writeLine(line); err != nil {
+			return err
+		}
+	}
+	return flushOutput(p.w)
+}
+
+func (p *buildProgress) writeLine(line string) error {
+	// 'go build -v' prints the import path of every package it compiles, on a line of its own
+	if p.skip[line] {
+		return nil
+	}
+	if p.deps[line] || (len(p.deps) == 0 && isImportPath(line)) {
+		p.compiled++
+		diag.packageCompiled(line, p.compiled, len(p.deps))
+		return nil
+	}
+	_, err := io.WriteString(p.w, line+"\n")
+	return err
+}
+
+// isImportPath returns whether the line of 'go build -v' output looks like an import path, for
+// when the dependencies couldn't be listed
+func isImportPath(line string) bool {
+	return line != "" && !strings.ContainsAny(line, " \t:#")
+}
+
+// quietMessages are the prefixes of the informational messages of go commands, which -q hides
+var quietMessages = []string{
+	"go: downloading ",
+	"go: finding ",
+	"go: extracting ",
+	"go: found ",
+	"go: added ",
+	"go: upgraded ",
+}
+
+// quietWriter passes the stderr of a go command on to w, except for quietMessages, for -q
+type quietWriter struct {
+	w       io.Writer
+	partial []byte
+}
+
+func (q *quietWriter) Write(b []byte) (int, error) {
+	q.partial = append(q.partial, b...)
+	for {
+		i := bytes.IndexByte(q.partial, '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+		line := q.partial[:i+1]
+		q.partial = q.partial[i+1:]
+		if isQuietMessage(string(line)) {
+			continue
+		}
+		if _, err := q.w.Write(line); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Flush writes what's left of the output after the go command exited, if it didn't end with a
+// newline
+func (q *quietWriter) Flush() error {
+	line := q.partial
+	q.partial = nil
+	if len(line) == 0 || isQuietMessage(string(line)) {
+		return nil
+	}
+	_, err := q.w.Write(line)
+	return err
+}
+
+// isQuietMessage returns whether the line starts with any of quietMessages
+func isQuietMessage(line string) bool {
+	for _, prefix := range quietMessages {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// flushOutput flushes w if it's one of the writers above, which hold back a line until it's
+// complete, so that the last one isn't lost when a go command doesn't end its output with a newline
+func flushOutput(w io.Writer) error {
+	if f, ok := w.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQuietWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{"messages hidden", []string{"go: downloading example.com/a v1.0.0\n", "build failed\n"}, "build failed\n"},
+		{"split lines", []string{"go: down", "loading example.com/a v1.0.0\nbuild ", "failed\n"}, "build failed\n"},
+		{"no trailing newline", []string{"go: downloading example.com/a v1.0.0\n", "exit status 1"}, "exit status 1"},
+		{"quiet message without newline", []string{"go: downloading example.com/a v1.0.0"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got strings.Builder
+			q := &quietWriter{w: &got}
+			for _, w := range tt.writes {
+				if _, err := q.Write([]byte(w)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := flushOutput(q); err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("quietWriter wrote %q, want %q", got.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// localReplaces returns the replace directives in go.mod that point to a local directory instead
+// of another module version
+func localReplaces(mf *modfile.File) []*modfile.Replace {
+	var replaces []*modfile.Replace
+	for _, r := range mf.Replace {
+		if modfile.IsDirectoryPath(r.New.Path) {
+			replaces = append(replaces, r)
+		}
+	}
+	return replaces
+}
+
+// prepareModuleDir produces the recipe for the module in dir, like prepareRecipe, and additionally
+// embeds the modules that go.mod replaces with local directories (unless those are stripped). With
+// -omit-gomod, the module's own go.mod and go.sum are left out afterwards.
+func prepareModuleDir(dir string, opts prepareOptions) (*recipe, error) {
+	opts.moduleDir = dir
+	r, err := prepareRecipe(os.DirFS(dir), opts)
+	if err != nil {
+		return nil, err
+	}
+	if !opts.stripLocalReplaces {
+		if err := embedLocalReplaces(r, dir, opts); err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range r.tiers {
+		// Tiers need the locally replaced modules to resolve the same dependency graph, but their
+		// imports are all cooked with the main recipe
+		for _, lr := range r.LocalReplaces {
+			lr.ImportGroups, lr.TestImportGroups, lr.Platforms, lr.RequiredModules = nil, nil, nil, nil
+			t.LocalReplaces = append(t.LocalReplaces, lr)
+		}
+	}
+	if opts.omitGoMod {
+		for _, m := range append([]*recipe{r}, r.tiers...) {
+			m.GoMod, m.GoSum = "", ""
+			m.OmitGoMod = true
+		}
+	}
+	return r, nil
+}
+
+// embedLocalReplaces adds the go.mod, go.sum, and external imports of each module that's replaced
+// with a local directory to the recipe. The replaced module's own packages can't be cooked, but
+// with its go.mod, cook can resolve the same dependency graph -- and build its dependencies.
+//
+// Replaced modules whose directory isn't there are skipped with a warning.
+func embedLocalReplaces(r *recipe, dir string, opts prepareOptions) error {
+	mf, err := modfile.Parse("go.mod", []byte(r.GoMod), nil)
+	if err != nil {
+		return fmt.Errorf("could not parse go.mod: %w", err)
+	}
+	replaces := localReplaces(mf)
+
+	// Imports between the module and the modules replacing its dependencies are all local. Test
+	// imports of dependencies are never built.
+	opts.workspaceModules = append(slices.Clip(opts.workspaceModules), mf.Module.Mod.Path)
+	for _, rep := range replaces {
+		opts.workspaceModules = append(opts.workspaceModules, rep.Old.Path)
+	}
+	opts.includeTests = false
+	opts.tiers = ""
+
+	for _, rep := range replaces {
+		target := rep.New.Path
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(dir, target)
+		}
+		if _, err := os.Stat(filepath.Join(target, "go.mod")); err != nil {
+			warnf("go.mod replaces %s with local directory %s, which cook can't resolve unless it's copied in first (see -strip-local-replaces)", rep.Old.Path, rep.New.Path)
+			continue
+		}
+		opts.moduleDir = target
+		m, err := prepareRecipe(os.DirFS(target), opts)
+		if err != nil {
+			return fmt.Errorf("could not prepare module %s, replaced by %s: %w", rep.Old.Path, rep.New.Path, err)
+		}
+		r.LocalReplaces = append(r.LocalReplaces, moduleRecipe{Dir: rep.New.Path, recipe: *m})
+	}
+	return nil
+}
+
+// stripLocalReplaces removes the replace directives pointing to local directories from go.mod,
+// together with the requirements on the modules they replace and those modules' go.sum lines.
+//
+// It returns the new contents of go.mod and go.sum. Imports of the removed modules' packages must
+// be dropped as well, because cook can't build them.
+func stripLocalReplaces(mf *modfile.File, modContents, sumContents []byte) (newModContents, newSumContents []byte, _ error) {
+	var stripped []string
+	for _, r := range localReplaces(mf) {
+		// copy, because dropping the directive clears r
+		old := r.Old
+		if err := mf.DropReplace(old.Path, old.Version); err != nil {
+			return nil, nil, fmt.Errorf("could not drop replace directive for %s: %w", old.Path, err)
+		}
+		if err := mf.DropRequire(old.Path); err != nil {
+			return nil, nil, fmt.Errorf("could not drop requirement on %s: %w", old.Path, err)
+		}
+		if !slices.Contains(stripped, old.Path) {
+			stripped = append(stripped, old.Path)
+		}
+	}
+	if len(stripped) == 0 {
+		return modContents, sumContents, nil
+	}
+	mf.Cleanup()
+	newModContents, err := mf.Format()
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not format go.mod: %w", err)
+	}
+
+	var sum strings.Builder
+	for _, line := range strings.SplitAfter(string(sumContents), "\n") {
+		if fields := strings.Fields(line); len(fields) == 0 || !slices.Contains(stripped, fields[0]) {
+			sum.WriteString(line)
+		}
+	}
+	return newModContents, []byte(sum.String()), nil
+}
+
+// cookReplacesDir is the directory in which cook recreates locally replaced modules. It's hidden so
+// that it doesn't match './...' patterns.
+const cookReplacesDir = ".chef-replaces"
+
+// writeLocalReplaceStubs recreates each locally replaced module in the recipe as a stub -- just its
+// go.mod and go.sum -- inside dir, and rewrites dir/go.mod to replace the modules with the stubs
+// instead.
+//
+// The stubs are kept inside the directory being cooked, because the original replacement paths
+// (like "../x") would point outside of it. That doesn't change what the dependencies compile to.
+func writeLocalReplaceStubs(dir string, m moduleRecipe) error {
+	if len(m.LocalReplaces) == 0 {
+		return nil
+	}
+	mf, err := modfile.Parse("go.mod", []byte(m.GoMod), nil)
+	if err != nil {
+		return fmt.Errorf("could not parse go.mod: %w", err)
+	}
+
+	for i, lr := range m.LocalReplaces {
+		stubDir := filepath.Join(dir, cookReplacesDir, strconv.Itoa(i))
+		if err := os.MkdirAll(stubDir, 0o777); err != nil {
+			return fmt.Errorf("could not create directory for replaced module %s: %w", lr.Dir, err)
+		}
+		if err := os.WriteFile(filepath.Join(stubDir, "go.mod"), []byte(lr.GoMod), 0o666); err != nil {
+			return fmt.Errorf("could not write go.mod of replaced module %s: %w", lr.Dir, err)
+		}
+		if err := os.WriteFile(filepath.Join(stubDir, "go.sum"), []byte(lr.GoSum), 0o666); err != nil {
+			return fmt.Errorf("could not write go.sum of replaced module %s: %w", lr.Dir, err)
+		}
+
+		stubPath := "./" + path.Join(cookReplacesDir, strconv.Itoa(i))
+		for _, rep := range localReplaces(mf) {
+			if rep.New.Path == lr.Dir {
+				if err := mf.AddReplace(rep.Old.Path, rep.Old.Version, stubPath, ""); err != nil {
+					return fmt.Errorf("could not replace %s: %w", rep.Old.Path, err)
+				}
+			}
+		}
+	}
+
+	mf.Cleanup()
+	modContents, err := mf.Format()
+	if err != nil {
+		return fmt.Errorf("could not format go.mod: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), modContents, 0o666); err != nil {
+		return fmt.Errorf("could not write go.mod: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteLocalReplaceStubs(t *testing.T) {
+	dir := t.TempDir()
+	m := moduleRecipe{Dir: ".", recipe: recipe{
+		GoMod: `module example.com/m
+
+go 1.22
+
+require (
+	example.com/a v1.0.0
+	example.com/b v1.0.0
+	example.com/c v1.0.0
+)
+
+replace example.com/a => ../a
+
+replace example.com/b v1.0.0 => ./third_party/b
+
+replace example.com/c => example.com/c v1.1.0
+`,
+		LocalReplaces: []moduleRecipe{
+			{Dir: "../a", recipe: recipe{GoMod: "module example.com/a\n", GoSum: "example.com/x v1.0.0 h1:x=\n"}},
+			{Dir: "./third_party/b", recipe: recipe{GoMod: "module example.com/b\n"}},
+		},
+	}}
+	if err := writeLocalReplaceStubs(dir, m); err != nil {
+		t.Fatal(err)
+	}
+
+	goMod, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Local replacements point to the stubs instead, versions stay as they are
+	want := `module example.com/m
+
+go 1.22
+
+require (
+	example.com/a v1.0.0
+	example.com/b v1.0.0
+	example.com/c v1.0.0
+)
+
+replace example.com/a => ./.chef-replaces/0
+
+replace example.com/b v1.0.0 => ./.chef-replaces/1
+
+replace example.com/c => example.com/c v1.1.0
+`
+	if string(goMod) != want {
+		t.Errorf("go.mod =\n%s\nwant:\n%s", goMod, want)
+	}
+
+	for path, want := range map[string]string{
+		".chef-replaces/0/go.mod": "module example.com/a\n",
+		".chef-replaces/0/go.sum": "example.com/x v1.0.0 h1:x=\n",
+		".chef-replaces/1/go.mod": "module example.com/b\n",
+		".chef-replaces/1/go.sum": "",
+	} {
+		got, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(path)))
+		if err != nil {
+			t.Error(err)
+		} else if string(got) != want {
+			t.Errorf("%s = %q, want %q", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// firstRetryDelay is how long cook waits before retrying a failed download for the first time.
+// Every retry after that waits twice as long as the one before.
+const firstRetryDelay = 2 * time.Second
+
+// transientErrors are parts of go command errors that mean a download may well work when tried
+// again: network timeouts, failed connections and resets, and server errors or rate limiting from
+// GOPROXY. They're the forms Go's net and net/http packages use, so that e.g. a module path that
+// happens to contain "timeout" doesn't count.
+var transientErrors = []string{
+	"i/o timeout",
+	"TLS handshake timeout",
+	"Client.Timeout exceeded",
+	"dial tcp",
+	"connection reset by peer",
+	"unexpected EOF",
+	"429 Too Many Requests",
+	"500 Internal Server Error",
+	"502 Bad Gateway",
+	"503 Service Unavailable",
+	"504 Gateway Timeout",
+}
+
+// downloadModules runs 'go mod download' in dir, which fetches every module needed to build the
+// packages there. If it fails with what looks like a transient error, it's retried up to -retries
+// times, with exponential backoff.
+//
+// Modules that were already downloaded by a failed attempt stay in the module cache, so a retry
+// only has to fetch the rest.
+func downloadModules(opts cookOptions, dir string) error {
+	delay := firstRetryDelay
+	for attempt := 1; ; attempt++ {
+		cmd := opts.goCommand("mod", "download")
+		cmd.Dir = dir
+		var stderr bytes.Buffer
+		out := cmd.Stderr
+		cmd.Stderr = io.MultiWriter(out, &stderr)
+		err := cmd.Run()
+		if ferr := flushOutput(out); err == nil {
+			err = ferr
+		}
+		if err == nil {
+			return nil
+		}
+		if isAuthError(stderr.String()) {
+			return errors.New("error: Could not download a private module, because credentials for it are missing or wrong. Pass them to cook with -netrc or -git-credentials")
+		}
+		if attempt > opts.retries || !isTransientError(stderr.String()) {
+			return fmt.Errorf("could not run 'go mod download' command: %w", err)
+		}
+		warnf("downloading modules failed with what looks like a temporary error, retrying in %s (retry %d of %d)", delay, attempt, opts.retries)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// isTransientError returns whether the go command's output contains any of transientErrors
+func isTransientError(output string) bool {
+	for _, s := range transientErrors {
+		if strings.Contains(output, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   bool
+	}{
+		{"read timeout", `go: example.com/a@v1.0.0: Get "https://proxy.golang.org/example.com/a/@v/v1.0.0.zip": read tcp 10.0.0.2:51234->142.250.74.49:443: i/o timeout`, true},
+		{"TLS handshake timeout", `go: example.com/a@v1.0.0: Get "https://proxy.golang.org/example.com/a/@v/v1.0.0.mod": net/http: TLS handshake timeout`, true},
+		{"client timeout", `go: example.com/a@v1.0.0: Get "https://proxy.golang.org/example.com/a/@v/list": context deadline exceeded (Client.Timeout exceeded while awaiting headers)`, true},
+		{"connection refused", `go: example.com/a@v1.0.0: Get "https://proxy.example.com/example.com/a/@v/v1.0.0.zip": dial tcp 10.0.0.1:443: connect: connection refused`, true},
+		{"DNS failure", `go: example.com/a@v1.0.0: Get "https://proxy.golang.org/example.com/a/@v/v1.0.0.zip": dial tcp: lookup proxy.golang.org on 127.0.0.53:53: server misbehaving`, true},
+		{"connection reset", `go: example.com/a@v1.0.0: read tcp 10.0.0.2:51234->142.250.74.49:443: read: connection reset by peer`, true},
+		{"truncated download", `go: example.com/a@v1.0.0: unexpected EOF`, true},
+		{"rate limited", `go: example.com/a@v1.0.0: reading https://proxy.example.com/example.com/a/@v/v1.0.0.zip: 429 Too Many Requests`, true},
+		{"bad gateway", `go: example.com/a@v1.0.0: reading https://proxy.golang.org/example.com/a/@v/v1.0.0.zip: 502 Bad Gateway`, true},
+		{"gateway timeout", `go: example.com/a@v1.0.0: reading https://proxy.golang.org/example.com/a/@v/v1.0.0.zip: 504 Gateway Timeout`, true},
+		{"unknown version", `go: example.com/a@v1.0.0: reading https://proxy.golang.org/example.com/a/@v/v1.0.0.info: 404 Not Found`, false},
+		{"module path with timeout", `go: example.com/timeout@v1.0.0: reading https://proxy.golang.org/example.com/timeout/@v/v1.0.0.info: 410 Gone`, false},
+		{"checksum mismatch", "verifying example.com/a@v1.0.0: checksum mismatch\n\tdownloaded: h1:a=\n\tgo.sum:     h1:b=", false},
+		{"auth", `go: example.com/private@v1.0.0: git ls-remote -q origin: exit status 128: fatal: could not read Username for 'https://example.com': terminal prompts disabled`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransientError(tt.output); got != tt.want {
+				t.Errorf("isTransientError(%q) = %v, want %v", tt.output, got, tt.want)
+			}
+		})
+	}
+}
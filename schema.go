@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// recipeSchema is the JSON Schema of the recipe format, for -print-schema and -validate
+//
+//go:embed recipe.schema.json
+var recipeSchema []byte
+
+const recipeSchemaURL = "https://github.com/neondatabase/go-chef/recipe.schema.json"
+
+// validateRecipe checks the recipe at recipePath against recipeSchema, returning an error that
+// lists every violation.
+func validateRecipe(recipePath string) error {
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource(recipeSchemaURL, bytes.NewReader(recipeSchema)); err != nil {
+		return fmt.Errorf("could not load recipe schema: %w", err)
+	}
+	schema, err := compiler.Compile(recipeSchemaURL)
+	if err != nil {
+		return fmt.Errorf("could not compile recipe schema: %w", err)
+	}
+
+	recipeJSON, err := readRecipeFile(recipePath)
+	if err != nil {
+		return fmt.Errorf("could not read recipe at %s: %w", recipePath, err)
+	}
+	var v any
+	if err := json.Unmarshal(recipeJSON, &v); err != nil {
+		return fmt.Errorf("error: Recipe at %s is not valid JSON: %w", recipePath, err)
+	}
+
+	err = schema.Validate(v)
+	var verr *jsonschema.ValidationError
+	if errors.As(err, &verr) {
+		// Only the leaves say what's actually wrong; the rest are the schema locations leading there
+		var problems []string
+		var collect func(e *jsonschema.ValidationError)
+		collect = func(e *jsonschema.ValidationError) {
+			if len(e.Causes) == 0 {
+				loc := e.InstanceLocation
+				if loc == "" {
+					loc = "/"
+				}
+				problems = append(problems, fmt.Sprintf("  at %s: %s", loc, e.Message))
+			}
+			// When the fields of a recipe don't validate, unevaluatedProperties also rejects every
+			// one of them, since only valid ones count as evaluated. That's only noise next to the
+			// actual problem.
+			causes := e.Causes
+			if slices.ContainsFunc(causes, func(c *jsonschema.ValidationError) bool { return !isUnevaluated(c) }) {
+				causes = slices.DeleteFunc(slices.Clone(causes), isUnevaluated)
+			}
+			for _, cause := range causes {
+				collect(cause)
+			}
+		}
+		collect(verr)
+		return fmt.Errorf("error: Recipe at %s doesn't match the recipe schema:\n%s", recipePath, strings.Join(problems, "\n"))
+	} else if err != nil {
+		return fmt.Errorf("could not validate recipe at %s: %w", recipePath, err)
+	}
+
+	var r recipe
+	if err := json.Unmarshal(recipeJSON, &r); err == nil && r.Version > recipeVersion {
+		return fmt.Errorf("error: Recipe at %s has format version %d, but this go-chef only supports up to version %d", recipePath, r.Version, recipeVersion)
+	}
+	return nil
+}
+
+// isUnevaluated returns whether e is about a field that unevaluatedProperties doesn't allow
+func isUnevaluated(e *jsonschema.ValidationError) bool {
+	return strings.HasSuffix(e.KeywordLocation, "/unevaluatedProperties")
+}
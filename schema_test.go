@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateRecipe(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(r map[string]any)
+		want   string
+	}{
+		{"full", func(map[string]any) {}, ""},
+		{"top-level dir", func(r map[string]any) { r["dir"] = "a" }, "at /dir: not allowed"},
+		{"unknown field", func(r map[string]any) { r["importGroup"] = []any{} }, "at /importGroup: not allowed"},
+		{"module without dir", func(r map[string]any) {
+			delete(r["modules"].([]any)[1].(map[string]any), "dir")
+		}, "at /modules/1: missing properties: 'dir'"},
+		{"unknown field in module", func(r map[string]any) {
+			r["modules"].([]any)[0].(map[string]any)["bogus"] = true
+		}, "at /modules/0/bogus: not allowed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			if err := writeRecipe(&b, fullRecipe(), false); err != nil {
+				t.Fatal(err)
+			}
+			var r map[string]any
+			if err := json.Unmarshal([]byte(b.String()), &r); err != nil {
+				t.Fatal(err)
+			}
+			tt.modify(r)
+			data, err := json.Marshal(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(t.TempDir(), "recipe.json")
+			if err := os.WriteFile(path, data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			err = validateRecipe(path)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("validateRecipe() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("validateRecipe() = nil, want an error with %q", tt.want)
+			}
+			// Only the actual problem, not every field of the recipe as unevaluated
+			if _, problems, _ := strings.Cut(err.Error(), "\n"); problems != "  "+tt.want {
+				t.Errorf("validateRecipe() = %v, want only %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// slowestPackages is how many of the packages that took longest to compile the stats list
+const slowestPackages = 10
+
+// cookStats are the statistics that cook reports at the end with -stats, and writes to -stats-file
+type cookStats struct {
+	// PackagesCompiled counts every package that had to be compiled (and wasn't a cache hit),
+	// once per build variant, like -race
+	PackagesCompiled int `json:"packagesCompiled"`
+	// CompileSeconds is the time spent compiling them, summed up over the packages, which can be
+	// more than the time the cook took since packages are compiled in parallel
+	CompileSeconds  float64 `json:"compileSeconds"`
+	DurationSeconds float64 `json:"durationSeconds"`
+	// GoCacheAdded and GoModCacheAdded are how much GOCACHE and GOMODCACHE grew, in bytes, or nil
+	// if their sizes couldn't be measured
+	GoCacheAdded    *int64 `json:"goCacheAddedBytes,omitempty"`
+	GoModCacheAdded *int64 `json:"goModCacheAddedBytes,omitempty"`
+
+	Slowest []packageTime `json:"slowestPackages"`
+}
+
+type packageTime struct {
+	Package string  `json:"package"`
+	Seconds float64 `json:"seconds"`
+}
+
+// statsCollector collects the compile times of the packages built during a cook, from the action
+// graphs of the 'go build' commands. It's only used with -stats or -stats-file, since those
+// commands have to write an action graph, and measuring the caches means walking both of them.
+type statsCollector struct {
+	mu       sync.Mutex
+	compiled []packageTime
+
+	start time.Time
+	// goCache and goModCache are the sizes of GOCACHE and GOMODCACHE before the cook, if sizesKnown
+	goCache, goModCache int64
+	sizesKnown          bool
+}
+
+// newStatsCollector starts collecting the stats of a cook. Measuring the caches is best-effort: if
+// it fails, only how much they grew is left out of the stats.
+func newStatsCollector(opts cookOptions) *statsCollector {
+	c := &statsCollector{}
+	var err error
+	if c.goCache, c.goModCache, err = cacheSizes(opts); err != nil {
+		warnf("could not measure the caches for the stats: %v", err)
+	} else {
+		c.sizesKnown = true
+	}
+	c.start = time.Now()
+	return c
+}
+
+// addActionGraph adds the packages that were compiled according to the action graph written by
+// 'go build -debug-actiongraph'. Cache hits have a build action as well, but no command.
+func (c *statsCollector) addActionGraph(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var actions []struct {
+		Mode      string
+		Package   string
+		Cmd       []string
+		TimeStart time.Time
+		TimeDone  time.Time
+	}
+	if err := json.Unmarshal(data, &actions); err != nil {
+		return fmt.Errorf("could not parse action graph: %w", err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, a := range actions {
+		if a.Mode == "build" && len(a.Cmd) != 0 && !compilesCookMain(a.Cmd) {
+			c.compiled = append(c.compiled, packageTime{Package: a.Package, Seconds: a.TimeDone.Sub(a.TimeStart).Seconds()})
+		}
+	}
+	return nil
+}
+
+// compilesCookMain returns whether the commands of a build action compile the generated main
+// package, which is recompiled by every cook and isn't a dependency, so it's left out of the stats
+func compilesCookMain(cmds []string) bool {
+	for _, cmd := range cmds {
+		for _, arg := range splitCommandLine(cmd) {
+			if filepath.Base(arg) == cookMainFile {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// splitCommandLine splits a command of the action graph into its arguments. The go command joins
+// them with spaces, quoting any argument that needs it like strconv.Quote, e.g. one with a space.
+// An argument whose quoting is broken is kept as it is, up to the next space.
+func splitCommandLine(cmd string) []string {
+	var args []string
+	for {
+		cmd = strings.TrimLeft(cmd, " ")
+		if cmd == "" {
+			return args
+		}
+		if quoted, err := strconv.QuotedPrefix(cmd); err == nil && cmd[0] == '"' {
+			// QuotedPrefix already made sure it's valid
+			arg, _ := strconv.Unquote(quoted)
+			args = append(args, arg)
+			cmd = cmd[len(quoted):]
+			continue
+		}
+		arg, rest, _ := strings.Cut(cmd, " ")
+		args = append(args, arg)
+		cmd = rest
+	}
+}
+
+// withActionGraph adds -debug-actiongraph to the 'go build' flags, if the stats of the cook are
+// collected. The returned function adds the packages that were compiled to the stats after the
+// build, and removes the action graph.
+func (opts cookOptions) withActionGraph(flags []string) ([]string, func(), error) {
+	if opts.stats == nil {
+		return flags, func() {}, nil
+	}
+	f, err := os.CreateTemp("", "go-chef-actiongraph-*.json")
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create action graph file: %w", err)
+	}
+	f.Close()
+	// Undocumented, but 'go build' has supported it since Go 1.10, and it's the only way to find
+	// out how long each package took to compile
+	flags = append(flags, "-debug-actiongraph="+f.Name())
+	return flags, func() {
+		// The stats are best-effort: a build that fails early doesn't write an action graph
+		_ = opts.stats.addActionGraph(f.Name())
+		os.Remove(f.Name())
+	}, nil
+}
+
+// stats returns the statistics of the cook so far
+func (c *statsCollector) stats(opts cookOptions) cookStats {
+	s := cookStats{DurationSeconds: time.Since(c.start).Seconds()}
+	if c.sizesKnown {
+		if goCache, goModCache, err := cacheSizes(opts); err != nil {
+			warnf("could not measure the caches for the stats: %v", err)
+		} else {
+			goCacheAdded, goModCacheAdded := goCache-c.goCache, goModCache-c.goModCache
+			s.GoCacheAdded, s.GoModCacheAdded = &goCacheAdded, &goModCacheAdded
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s.PackagesCompiled = len(c.compiled)
+	for _, p := range c.compiled {
+		s.CompileSeconds += p.Seconds
+	}
+	s.Slowest = slices.Clone(c.compiled)
+	slices.SortStableFunc(s.Slowest, func(a, b packageTime) int {
+		return cmp.Compare(b.Seconds, a.Seconds)
+	})
+	s.Slowest = s.Slowest[:min(len(s.Slowest), slowestPackages)]
+	return s
+}
+
+// cacheSizes returns the total size of the files in GOCACHE and GOMODCACHE
+func cacheSizes(opts cookOptions) (goCache, goModCache int64, _ error) {
+	env, err := readGoEnv(opts.goCommand(), []string{"GOCACHE", "GOMODCACHE"})
+	if err != nil {
+		return 0, 0, err
+	}
+	if goCache, err = dirSize(env["GOCACHE"]); err != nil {
+		return 0, 0, fmt.Errorf("could not get size of GOCACHE: %w", err)
+	}
+	if goModCache, err = dirSize(env["GOMODCACHE"]); err != nil {
+		return 0, 0, fmt.Errorf("could not get size of GOMODCACHE: %w", err)
+	}
+	return goCache, goModCache, nil
+}
+
+// dirSize returns the total size of the files in dir, which is 0 if it doesn't exist (yet)
+func dirSize(dir string) (int64, error) {
+	if dir == "" || dir == "off" {
+		return 0, nil
+	}
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				// Also temporary files that were removed in the meantime
+				return nil
+			}
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// writeStatsFile writes the stats as JSON to the file of -stats-file
+func writeStatsFile(path string, s cookStats) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("could not write -stats-file: %w", err)
+	}
+	return nil
+}
+
+// formatBytes formats a size in bytes for humans, like '12.3 MB'
+func formatBytes(n int64) string {
+	if n < 1000 && n > -1000 {
+		return fmt.Sprintf("%d B", n)
+	}
+	f := float64(n)
+	for _, unit := range []string{"kB", "MB", "GB"} {
+		f /= 1000
+		if math.Abs(f) < 1000 {
+			return fmt.Sprintf("%.1f %s", f, unit)
+		}
+	}
+	return fmt.Sprintf("%.1f TB", f/1000)
+}
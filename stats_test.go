@@ -0,0 +1,47 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCompilesCookMain(t *testing.T) {
+	tests := []struct {
+		name string
+		cmds []string
+		want bool
+	}{
+		{"generated main package", []string{`/usr/local/go/pkg/tool/linux_amd64/compile -o /tmp/go-build1/b001/_pkg_.a -trimpath "/tmp/go-build1/b001=>" -p main -pack /tmp/go-chef-cook-1/chef_main.go /tmp/go-chef-cook-1/chef_linux_1a2b3c4d.go`}, true},
+		{"quoted path", []string{`compile -p main -trimpath "/tmp/my dir=>" -pack "/tmp/my dir/chef_main.go"`}, true},
+		{"quoted argument ending like it", []string{`compile -p example.com/a -pack "/tmp/chef_main.go x/a.go"`}, false},
+		{"escaped quote", []string{`compile -p main -pack "/tmp/a\" b/chef_main.go"`}, true},
+		{"dependency", []string{`compile -o /tmp/go-build1/b002/_pkg_.a -p golang.org/x/mod/semver -pack /root/go/pkg/mod/golang.org/x/mod@v0.22.0/semver/semver.go`}, false},
+		{"file named like it", []string{`compile -p example.com/a -pack /src/not_chef_main.go`}, false},
+		{"cache hit", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := compilesCookMain(tt.cmds); got != tt.want {
+				t.Errorf("compilesCookMain(%q) = %v, want %v", tt.cmds, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitCommandLine(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want []string
+	}{
+		{`compile -o /tmp/b001/_pkg_.a -p main`, []string{"compile", "-o", "/tmp/b001/_pkg_.a", "-p", "main"}},
+		{`compile -trimpath "/tmp/go-build1/b001=>" -pack  a.go`, []string{"compile", "-trimpath", "/tmp/go-build1/b001=>", "-pack", "a.go"}},
+		{`compile "/tmp/my dir/a.go" "b\"c"`, []string{"compile", "/tmp/my dir/a.go", `b"c`}},
+		{`compile "unterminated a.go`, []string{"compile", `"unterminated`, "a.go"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := splitCommandLine(tt.cmd); !slices.Equal(got, tt.want) {
+			t.Errorf("splitCommandLine(%q) = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
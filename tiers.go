@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// tierPatterns returns the package patterns of each tier given to -tiers: tiers are separated by
+// semicolons, and the patterns within each by commas.
+func (opts prepareOptions) tierPatterns() [][]string {
+	var tiers [][]string
+	for _, tier := range strings.Split(opts.tiers, ";") {
+		var patterns []string
+		for _, p := range strings.Split(tier, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				patterns = append(patterns, p)
+			}
+		}
+		if len(patterns) != 0 {
+			tiers = append(tiers, patterns)
+		}
+	}
+	return tiers
+}
+
+// matchPackagePattern returns whether the import path matches the pattern, where "..." matches any
+// string like in 'go list' patterns -- so "k8s.io/..." matches "k8s.io" and everything below it.
+func matchPackagePattern(pattern, pkg string) bool {
+	re := regexp.QuoteMeta(pattern)
+	re = strings.ReplaceAll(re, `\.\.\.`, `.*`)
+	if strings.HasSuffix(re, `/.*`) {
+		re = strings.TrimSuffix(re, `/.*`) + `(/.*)?`
+	}
+	matched, _ := regexp.MatchString("^"+re+"$", pkg)
+	return matched
+}
+
+// splitTier moves the packages matching any of the patterns out of the recipe and into a new
+// recipe for the tier, which otherwise has the same go.mod and go.sum.
+func splitTier(r *recipe, patterns []string) *recipe {
+	inTier := func(pkg string) bool {
+		return slices.ContainsFunc(patterns, func(pattern string) bool {
+			return matchPackagePattern(pattern, pkg)
+		})
+	}
+	t := &recipe{
+		GoMod:     r.GoMod,
+		GoSum:     r.GoSum,
+		GoVersion: r.GoVersion,
+		Toolchain: r.Toolchain,
+	}
+	t.ImportGroups, r.ImportGroups = partitionGroups(r.ImportGroups, inTier)
+	t.TestImportGroups, r.TestImportGroups = partitionGroups(r.TestImportGroups, inTier)
+	if len(r.Platforms) != 0 {
+		t.Platforms = make(map[string]platformRecipe)
+		for platform, p := range r.Platforms {
+			var tp platformRecipe
+			tp.ImportGroups, p.ImportGroups = partitionGroups(p.ImportGroups, inTier)
+			tp.TestImportGroups, p.TestImportGroups = partitionGroups(p.TestImportGroups, inTier)
+			r.Platforms[platform] = p
+			t.Platforms[platform] = tp
+		}
+	}
+	return t
+}
+
+// partitionGroups splits the import groups into the ones with only the matching packages, and the
+// ones with the rest. Groups left without any packages are dropped.
+func partitionGroups(groups []importGroup, match func(pkg string) bool) (matching, rest []importGroup) {
+	for _, g := range groups {
+		m, other := g, g
+		m.Packages, other.Packages = nil, nil
+		for _, pkg := range g.Packages {
+			if match(pkg) {
+				m.Packages = append(m.Packages, pkg)
+			} else {
+				other.Packages = append(other.Packages, pkg)
+			}
+		}
+		if len(m.Packages) != 0 {
+			matching = append(matching, m)
+		}
+		if len(other.Packages) != 0 {
+			rest = append(rest, other)
+		}
+	}
+	return matching, rest
+}
+
+// tierRecipePath returns the path of the recipe for the tier with the (0-based) index, next to the
+// main recipe: "recipe.tier1.json" for "recipe.json".
+func tierRecipePath(recipePath string, index int) string {
+	ext := filepath.Ext(recipePath)
+	return fmt.Sprintf("%s.tier%d%s", strings.TrimSuffix(recipePath, ext), index+1, ext)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// checkVendorConsistency warns if the module has a vendor directory whose vendor/modules.txt
+// doesn't match the requirements in go.mod. Cooking with -mod=vendor would then fail with an
+// "inconsistent vendoring" error.
+func checkVendorConsistency(fsys fs.FS, mf *modfile.File) {
+	contents, err := fs.ReadFile(fsys, "vendor/modules.txt")
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	} else if err != nil {
+		warnf("could not read vendor/modules.txt: %s", err)
+		return
+	}
+
+	// Lines starting with "# " name a module, like "# golang.org/x/text v0.14.0", optionally
+	// followed by "=> replacement". A following "## explicit" line means that it's required in
+	// go.mod. Everything else is a package list.
+	versions := make(map[string]string)
+	explicit := make(map[string]bool)
+	var current string
+	for _, line := range strings.Split(string(contents), "\n") {
+		switch {
+		case strings.HasPrefix(line, "# "):
+			fields := strings.Fields(line[2:])
+			current = ""
+			if len(fields) >= 2 && fields[1] != "=>" {
+				current = fields[0]
+				versions[current] = fields[1]
+			}
+		case strings.HasPrefix(line, "## ") && current != "":
+			for _, annotation := range strings.Split(line[3:], ";") {
+				if strings.TrimSpace(annotation) == "explicit" {
+					explicit[current] = true
+				}
+			}
+		}
+	}
+
+	required := make(map[string]bool)
+	for _, req := range mf.Require {
+		required[req.Mod.Path] = true
+		switch v, ok := versions[req.Mod.Path]; {
+		case !ok:
+			warnf("inconsistent vendoring: %s is required in go.mod but missing from vendor/modules.txt (run 'go mod vendor')", req.Mod)
+		case v != req.Mod.Version:
+			warnf("inconsistent vendoring: %s is %s in go.mod but %s in vendor/modules.txt (run 'go mod vendor')", req.Mod.Path, req.Mod.Version, v)
+		}
+	}
+	for path := range explicit {
+		if !required[path] {
+			warnf("inconsistent vendoring: %s is marked explicit in vendor/modules.txt but not required in go.mod (run 'go mod vendor')", path)
+		}
+	}
+}
+
+// checkVendorPresent returns an error if a vendor directory that -mod=vendor needs isn't there.
+// Cook doesn't create vendor directories, so they have to be copied in before it runs.
+func checkVendorPresent(r *recipe, modules []moduleRecipe) error {
+	// Workspaces have a single vendor directory, next to go.work
+	dirs := []string{"."}
+	if r.GoWork == "" {
+		dirs = dirs[:0]
+		for _, m := range modules {
+			dirs = append(dirs, filepath.FromSlash(m.Dir))
+		}
+	}
+	for _, dir := range dirs {
+		path := filepath.Join(dir, "vendor", "modules.txt")
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("error: -mod=vendor needs %s, copy the vendor directory in before running cook: %w", path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// findGoWork returns the path of the go.work file that the go command would use when run in dir,
+// or "" if it wouldn't be in workspace mode.
+//
+// Like the go command, an explicit GOWORK takes precedence. Otherwise, the closest go.work in dir
+// or any of its parents is used.
+func findGoWork(dir string) (string, error) {
+	switch gowork := os.Getenv("GOWORK"); gowork {
+	case "off":
+		return "", nil
+	case "":
+		// search below
+	default:
+		return gowork, nil
+	}
+
+	return findUpward(dir, "go.work")
+}
+
+// findModuleRoot returns the directory of the closest go.mod in dir or any of its parents, like the
+// go command does, or an error if there isn't one.
+func findModuleRoot(dir string) (string, error) {
+	path, err := findUpward(dir, "go.mod")
+	if err != nil {
+		return "", fmt.Errorf("could not search for go.mod: %w", err)
+	} else if path == "" {
+		return "", errors.New("could not find go.mod in the current directory or any parent directory")
+	}
+	return filepath.Dir(path), nil
+}
+
+// findUpward returns the path of the closest file with the given name in dir or any of its parents,
+// or "" if there's none.
+func findUpward(dir string, name string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// checkNoGoWork returns an error if the go command would run in workspace mode in dir, unless
+// gowork is "off".
+//
+// In workspace mode, dependencies are resolved from all the modules in the workspace together, so
+// the versions actually built may not match the ones in the module's go.mod -- meaning the recipe
+// would warm the cache for the wrong thing.
+func checkNoGoWork(dir string, gowork string) error {
+	if gowork == "off" {
+		return nil
+	}
+	path, err := findGoWork(dir)
+	if err != nil {
+		return fmt.Errorf("could not check for go.work: %w", err)
+	}
+	if path != "" {
+		return fmt.Errorf("error: Workspace file %s would change how dependencies are resolved. Pass -gowork=off to both -prepare and -cook to ignore it", path)
+	}
+	return nil
+}
+
+// prepareWorkspace produces the recipe for all the modules in the workspace defined by the go.work
+// file at goWorkPath.
+func prepareWorkspace(goWorkPath string, opts prepareOptions) (*recipe, error) {
+	workContents, err := os.ReadFile(goWorkPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read go.work: %w", err)
+	}
+	wf, err := modfile.ParseWork(goWorkPath, workContents, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse go.work: %w", err)
+	}
+	// go.work.sum only exists if the workspace needed checksums beyond the modules' own go.sum files
+	workSumContents, err := os.ReadFile(goWorkPath + ".sum")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("could not read go.work.sum: %w", err)
+	}
+
+	root := filepath.Dir(goWorkPath)
+	var dirs []string
+	for _, use := range wf.Use {
+		dir := use.Path
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(root, dir)
+		}
+		rel, err := filepath.Rel(root, dir)
+		if err != nil || !filepath.IsLocal(rel) {
+			return nil, fmt.Errorf("error: Workspace module %s is outside of the directory containing go.work, so it can't be included in the recipe", use.Path)
+		}
+		dirs = append(dirs, rel)
+	}
+
+	// We need the paths of all modules before scanning any of them, so that imports between them
+	// are recognized as local.
+	for _, dir := range dirs {
+		modContents, err := os.ReadFile(filepath.Join(root, dir, "go.mod"))
+		if err != nil {
+			return nil, fmt.Errorf("could not read go.mod of workspace module %s: %w", dir, err)
+		}
+		opts.workspaceModules = append(opts.workspaceModules, modfile.ModulePath(modContents))
+	}
+
+	r := &recipe{
+		GoWork:    string(workContents),
+		GoWorkSum: string(workSumContents),
+	}
+	if wf.Go != nil {
+		r.GoVersion = wf.Go.Version
+	}
+	if wf.Toolchain != nil {
+		r.Toolchain = wf.Toolchain.Name
+	}
+	for _, dir := range dirs {
+		m, err := prepareModuleDir(filepath.Join(root, dir), opts)
+		if err != nil {
+			return nil, fmt.Errorf("could not prepare workspace module %s: %w", dir, err)
+		}
+		r.Modules = append(r.Modules, moduleRecipe{Dir: filepath.ToSlash(dir), recipe: *m})
+	}
+	return r, nil
+}
+
+// prepareRecursive produces the recipe for every module in or below root -- i.e., every directory
+// with a go.mod -- as if they were all part of one workspace, but without a go.work.
+//
+// Like in a workspace, imports between the modules are treated as local, since those are usually
+// resolved through replace directives pointing at each other.
+func prepareRecursive(root string, opts prepareOptions) (*recipe, error) {
+	skipDirs := opts.skipDirList()
+	var dirs []string
+	err := fs.WalkDir(os.DirFS(root), ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			if d.Name() == "go.mod" {
+				dirs = append(dirs, path.Dir(p))
+			}
+			return nil
+		}
+		if p != "." && (strings.HasPrefix(d.Name(), ".") || slices.Contains(skipDirs, d.Name())) {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not search for modules: %w", err)
+	}
+	if len(dirs) == 0 {
+		return nil, errors.New("could not find any go.mod in the current directory or below it")
+	}
+
+	for _, dir := range dirs {
+		modContents, err := os.ReadFile(filepath.Join(root, dir, "go.mod"))
+		if err != nil {
+			return nil, fmt.Errorf("could not read go.mod of module %s: %w", dir, err)
+		}
+		opts.workspaceModules = append(opts.workspaceModules, modfile.ModulePath(modContents))
+	}
+
+	r := &recipe{}
+	for _, dir := range dirs {
+		m, err := prepareModuleDir(filepath.Join(root, dir), opts)
+		if err != nil {
+			return nil, fmt.Errorf("could not prepare module %s: %w", dir, err)
+		}
+		r.Modules = append(r.Modules, moduleRecipe{Dir: dir, recipe: *m})
+	}
+	return r, nil
+}